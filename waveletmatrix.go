@@ -31,11 +31,10 @@ package waveletmatrix
 import (
 	"bytes"
 	"encoding"
-	"encoding/binary"
 	"errors"
+	"io"
 
 	"github.com/hideo55/go-pq"
-	"github.com/hideo55/go-sbvector"
 )
 
 // WMData holds information of Wavelet-Matrix
@@ -43,9 +42,15 @@ type WMData struct {
 	size           uint64
 	alphabetNum    uint64
 	alphabetBitNum uint64
-	bv             []*sbvector.BitVectorData
-	nodePos        [][]uint64
-	seps           []uint64
+	bv             []bitVector
+	// backend creates and restores the bit vectors of the levels.
+	backend bitVectorBackend
+	// zeros holds the number of 0 bits in each level. In level i, the elements whose bit is 1
+	// are placed after zeros[i] in the next level.
+	zeros []uint64
+	// weightSums holds the cumulative weights of the elements, if Wavelet-Matrix is weighted.
+	// weightSums[0] is in the order of the array, and weightSums[i+1] is in the order of the level i+1.
+	weightSums [][]uint64
 }
 
 // ListResult is result of list* API
@@ -57,8 +62,6 @@ type ListResult struct {
 }
 
 type queryOnNode struct {
-	begNode    uint64
-	endNode    uint64
 	begPos     uint64
 	endPos     uint64
 	depth      uint64
@@ -69,6 +72,10 @@ type queryOnNode struct {
 type WaveletMatrix interface {
 	encoding.BinaryMarshaler
 	encoding.BinaryUnmarshaler
+	io.WriterTo
+	io.ReaderFrom
+	Checked() CheckedWaveletMatrix
+	Validate(opts ...ValidateOption) error
 	Size() uint64
 	Lookup(pos uint64) (uint64, bool)
 	Rank(c, pos uint64) (uint64, bool)
@@ -83,9 +90,15 @@ type WaveletMatrix interface {
 	QuantileRange(begPos, endPos, k uint64) (pos, val uint64)
 	MaxRange(begPos, endPos uint64) (pos, val uint64)
 	MinRange(begPos, endPos uint64) (pos, val uint64)
+	NextValue(begPos, endPos, x uint64) (pos, val uint64)
+	PrevValue(begPos, endPos, x uint64) (pos, val uint64)
 	ListModeRange(minC, maxC, begPos, endPos, num uint64) []ListResult
 	ListMinRange(minC, maxC, begPos, endPos, num uint64) []ListResult
 	ListMaxRange(minC, maxC, begPos, endPos, num uint64) []ListResult
+	Extract(begPos, endPos uint64) []uint64
+	Slice(begPos, endPos uint64) (WaveletMatrix, error)
+	DistinctRange(begPos, endPos uint64) uint64
+	DistinctValueRange(minC, maxC, begPos, endPos uint64) uint64
 }
 
 const (
@@ -94,13 +107,43 @@ const (
 
 	sizeOfInt32 uint64 = 4
 	sizeOfInt64 uint64 = 8
+
+	// formatMarker was placed at the head of the binary of the versions before formatMagic, which are not supported.
+	formatMarker uint64 = NotFound
+	// formatVersion is the version of the format which starts with formatMagic, and ends with CRC32C checksum.
+	formatVersion uint32 = 4
+
+	// formatFlagWeights indicates that the cumulative weights follow the number of 0 bits.
+	formatFlagWeights uint32 = 1 << 0
+	// formatFlagBackend indicates that the name of the bit vector backend follows the flags.
+	// Without it, the bit vectors are of defaultBitVectorBackend.
+	formatFlagBackend uint32 = 1 << 1
+	// formatFlagMultiary indicates that the levels hold symbols of multiple bits, which is read by MultiaryWMData.
+	formatFlagMultiary uint32 = 1 << 2
+	// formatFlagCompact indicates that the distinct values of CompactWMData follow the name of the bit vector backend.
+	formatFlagCompact uint32 = 1 << 3
+	// formatFlagMapped indicates the mapped layout written by WriteMappedTo, which is read by MappedWMData.
+	formatFlagMapped uint32 = 1 << 4
+	// formatFlagsKnown is the set of flags which this version can read.
+	formatFlagsKnown = formatFlagWeights | formatFlagBackend | formatFlagMultiary | formatFlagCompact | formatFlagMapped
+
+	// maxBackendNameLen is the maximum length of the name of the bit vector backend in the binary.
+	maxBackendNameLen uint64 = 255
 )
 
+// formatMagic is placed at the head of the binary, followed by the version and the flags.
+var formatMagic = [8]byte{'W', 'V', 'L', 'T', 'M', 'T', 'R', 'X'}
+
 var (
 	// ErrorInvalidFormat indicates that binary format is invalid.
 	ErrorInvalidFormat = errors.New("UnmarshalBinary: invalid binary format")
+	// ErrorUnsupportedVersion indicates that version of binary format is not supported.
+	ErrorUnsupportedVersion = errors.New("UnmarshalBinary: unsupported format version")
+	// ErrorChecksumMismatch indicates that the binary is corrupted.
+	ErrorChecksumMismatch = errors.New("UnmarshalBinary: checksum mismatch")
 )
 
+// NewWMFromBinary restores Wavelet-Matrix from the binary created by MarshalBinary.
 func NewWMFromBinary(data []byte) (WaveletMatrix, error) {
 	wm := new(WMData)
 	err := wm.UnmarshalBinary(data)
@@ -112,17 +155,113 @@ func (wm *WMData) Size() uint64 {
 	return wm.size
 }
 
+// Checked returns the view of wm whose API reports invalid arguments by errors.
+func (wm *WMData) Checked() CheckedWaveletMatrix {
+	return checkedMatrix{wm}
+}
+
+func (wm *WMData) alphabetSize() uint64 {
+	return wm.alphabetNum
+}
+
 // Lookup element by pos.
 // This function returns value of pos-th element of wavelet-matrix.
 // if pos >= (size of wavelet-matrix),  value of second result parameter is false.
 func (wm *WMData) Lookup(pos uint64) (uint64, bool) {
-	if pos >= wm.size {
-		return NotFound, false
-	}
+	return legacyMatrix{wm.Checked()}.Lookup(pos)
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (wm *WMData) Rank(c, pos uint64) (uint64, bool) {
+	return legacyMatrix{wm.Checked()}.Rank(c, pos)
+}
+
+// RankAll returns the frequency of characters c' < c, c'=c, and c' > c, in the subarray A[begPos...endPos)
+func (wm *WMData) RankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
+	return legacyMatrix{wm.Checked()}.RankAll(c, beginPos, endPos)
+}
+
+// RankLessThan returns the frequency of characters c' < c in the subarray A[0...pos)
+func (wm *WMData) RankLessThan(c, pos uint64) uint64 {
+	return legacyMatrix{wm.Checked()}.RankLessThan(c, pos)
+}
+
+// RankMoreThan returns the frequency of characters c' > c in the subarray A[0...pos)
+func (wm *WMData) RankMoreThan(c, pos uint64) uint64 {
+	return legacyMatrix{wm.Checked()}.RankMoreThan(c, pos)
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (wm *WMData) Select(c, rank uint64) (uint64, bool) {
+	return legacyMatrix{wm.Checked()}.Select(c, rank)
+}
+
+// SelectFromPos returns the position of the rank-th occurrence of `c` in the suffix of the array starting from 'pos'
+func (wm *WMData) SelectFromPos(c, pos, rank uint64) (uint64, bool) {
+	return legacyMatrix{wm.Checked()}.SelectFromPos(c, pos, rank)
+}
+
+// Freq returns the frequency of the character `c`.
+func (wm *WMData) Freq(c uint64) uint64 {
+	return legacyMatrix{wm.Checked()}.Freq(c)
+}
+
+// FreqSum returns frequency of the characters(minC <= c' < maxC)
+func (wm *WMData) FreqSum(minC, maxC uint64) uint64 {
+	return legacyMatrix{wm.Checked()}.FreqSum(minC, maxC)
+}
+
+// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
+func (wm *WMData) FreqRange(minC, maxC, begPos, endPos uint64) uint64 {
+	return legacyMatrix{wm.Checked()}.FreqRange(minC, maxC, begPos, endPos)
+}
+
+// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
+func (wm *WMData) QuantileRange(begPos, endPos, k uint64) (pos, val uint64) {
+	return legacyMatrix{wm.Checked()}.QuantileRange(begPos, endPos, k)
+}
+
+// MaxRange returns maximum value(and position) in the subarray A[begPos .. endPos]
+func (wm *WMData) MaxRange(begPos, endPos uint64) (pos, val uint64) {
+	return legacyMatrix{wm.Checked()}.MaxRange(begPos, endPos)
+}
+
+// MinRange returns minimum value(and position) in the subarray A[begPos .. endPos]
+func (wm *WMData) MinRange(begPos, endPos uint64) (pos, val uint64) {
+	return legacyMatrix{wm.Checked()}.MinRange(begPos, endPos)
+}
+
+// NextValue returns the smallest value c >= x(and the position of its first occurrence) in the subarray A[begPos ... endPos)
+func (wm *WMData) NextValue(begPos, endPos, x uint64) (pos, val uint64) {
+	return legacyMatrix{wm.Checked()}.NextValue(begPos, endPos, x)
+}
+
+// PrevValue returns the largest value c < x(and the position of its last occurrence) in the subarray A[begPos ... endPos)
+func (wm *WMData) PrevValue(begPos, endPos, x uint64) (pos, val uint64) {
+	return legacyMatrix{wm.Checked()}.PrevValue(begPos, endPos, x)
+}
+
+// ListModeRange returns list of the distinct characters appeared in A[begPos ... endPos) from most frequent ones.
+func (wm *WMData) ListModeRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{wm.Checked()}.ListModeRange(minC, maxC, begPos, endPos, num)
+}
+
+// ListMinRange returns list of the distinct characters in A[begPos ... endPos) minC <= c < maxC  from smallest ones.
+func (wm *WMData) ListMinRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{wm.Checked()}.ListMinRange(minC, maxC, begPos, endPos, num)
+}
+
+// ListMaxRange returns list of the distinct characters appeared in A[begPos ... endPos) from largest ones.
+func (wm *WMData) ListMaxRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{wm.Checked()}.ListMaxRange(minC, maxC, begPos, endPos, num)
+}
+
+// lookup returns value of pos-th element without argument checks.
+func (wm *WMData) lookup(pos uint64) uint64 {
 	index := pos
 	c := uint64(0)
 
-	for i := 0; i < len(wm.bv); i++ {
+	for i := uint64(0); i < wm.alphabetBitNum; i++ {
 		b, _ := wm.bv[i].Get(index)
 		bit := uint64(0)
 		if b {
@@ -130,202 +269,116 @@ func (wm *WMData) Lookup(pos uint64) (uint64, bool) {
 		}
 		c <<= 1
 		c |= bit
-		index, _ = wm.bv[i].Rank(index, b)
-		if b {
-			index += wm.nodePos[i][1]
-		}
+		index = wm.nextPos(i, index, b)
 	}
-	return c, true
+	return c
 }
 
-// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
-func (wm *WMData) Rank(c, pos uint64) (uint64, bool) {
-	if c >= wm.alphabetNum || pos > wm.size {
-		return NotFound, false
-	}
-
-	if pos == 0 {
-		return 0, false
-	}
-
-	beginPos := wm.nodePos[wm.alphabetBitNum-uint64(1)][c]
-	endPos := pos
-
+// rank returns the frequency of `c` in A[begPos...endPos) without argument checks.
+func (wm *WMData) rank(c, beginPos, endPos uint64) uint64 {
 	for i := uint64(0); i < wm.alphabetBitNum; i++ {
-		bv := wm.bv[i]
-		bit := (c >> (wm.alphabetBitNum - i - uint64(1))) & uint64(1)
-		b := toBool(bit)
-		endPos, _ = bv.Rank(endPos, b)
-		if b {
-			endPos += wm.nodePos[i][1]
-		}
+		b := wm.bitOf(c, i)
+		beginPos = wm.nextPos(i, beginPos, b)
+		endPos = wm.nextPos(i, endPos, b)
 	}
 
-	return endPos - beginPos, true
+	return endPos - beginPos
 }
 
-// RankAll returns the frequency of characters c' < c, c'=c, and c' > c, in the subarray A[begPos...endPos)
-func (wm *WMData) RankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
-	if c >= wm.alphabetNum || beginPos >= wm.size || endPos > wm.size {
-		rank = NotFound
-		rankLessThan = NotFound
-		rankMoreThan = NotFound
-		return
-	}
+// rankAll returns the frequency of characters c' < c, c'=c, and c' > c, in A[begPos...endPos) without argument checks.
+// c must be less than 2^alphabetBitNum.
+func (wm *WMData) rankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
 	rank, rankLessThan, rankMoreThan = uint64(0), uint64(0), uint64(0)
 
 	if beginPos >= endPos {
 		return
 	}
 
-	begNode := uint64(0)
-	endNode := wm.size
-	pos := endPos
-
 	for i := uint64(0); i < wm.alphabetBitNum && beginPos < endPos; i++ {
 		bv := wm.bv[i]
-		bit := (c >> (wm.alphabetBitNum - i - uint64(1))) & 1
-		b := toBool(bit)
-		begNodeZero, _ := bv.Rank0(begNode)
-		begNodeOne := begNode - begNodeZero
-		endNodeZero, _ := bv.Rank0(endNode)
-		boundary := begNode + endNodeZero - begNodeZero
-
-		rankZero, _ := bv.Rank0(pos)
-		rankOne, _ := bv.Rank1(pos)
+		b := wm.bitOf(c, i)
+		begZero, _ := bv.Rank0(beginPos)
+		endZero, _ := bv.Rank0(endPos)
 
 		if b {
-			rankLessThan += rankZero - begNodeZero
-			pos = boundary + rankOne - (begNode - begNodeZero)
-			begNode = boundary
+			rankLessThan += endZero - begZero
+			beginPos = wm.zeros[i] + beginPos - begZero
+			endPos = wm.zeros[i] + endPos - endZero
 		} else {
-			rankMoreThan += rankOne - begNodeOne
-			pos = begNode + rankZero - begNodeZero
-			endNode = boundary
+			rankMoreThan += (endPos - endZero) - (beginPos - begZero)
+			beginPos = begZero
+			endPos = endZero
 		}
 	}
-	rank = pos - begNode
+	rank = endPos - beginPos
 	return
 }
 
-// RankLessThan returns the frequency of characters c' < c in the subarray A[0...pos)
-func (wm *WMData) RankLessThan(c, pos uint64) uint64 {
-	_, rank, _ := wm.RankAll(c, 0, pos)
-	return rank
-}
-
-// RankMoreThan returns the frequency of characters c' > c in the subarray A[0...pos)
-func (wm *WMData) RankMoreThan(c, pos uint64) uint64 {
-	_, _, rank := wm.RankAll(c, 0, pos)
+// rankLessThan returns the frequency of characters c' < c in the subarray A[begPos...endPos). c may be any value.
+func (wm *WMData) rankLessThan(c, begPos, endPos uint64) uint64 {
+	if c >= wm.alphabetNum {
+		return endPos - begPos
+	}
+	_, rank, _ := wm.rankAll(c, begPos, endPos)
 	return rank
 }
 
-// Select returns the position of the (rank+1)-th occurrence of `c` in the array.
-func (wm *WMData) Select(c, rank uint64) (uint64, bool) {
-	return wm.SelectFromPos(c, 0, rank)
-}
-
-// SelectFromPos returns the position of the (rank+1)-th occurrence of `c` in the suffix of the array starting from 'pos'
-func (wm *WMData) SelectFromPos(c, pos, rank uint64) (uint64, bool) {
-	if c >= wm.alphabetNum || pos >= wm.size || rank > wm.Freq(c) {
+// selectFromPos returns the position of the rank-th occurrence of `c` in A[pos...size).
+// If `c` does not occur rank times, the second result is false.
+func (wm *WMData) selectFromPos(c, pos, rank uint64) (uint64, bool) {
+	// Derive the range of `c` in A[pos...size) on the bottom level.
+	index := pos
+	endPos := wm.size
+	for i := uint64(0); i < wm.alphabetBitNum; i++ {
+		b := wm.bitOf(c, i)
+		index = wm.nextPos(i, index, b)
+		endPos = wm.nextPos(i, endPos, b)
+	}
+	if endPos-index < rank {
 		return NotFound, false
 	}
 
-	index := uint64(0)
-	if pos == 0 {
-		index = wm.nodePos[wm.alphabetBitNum-uint64(1)][c]
-	} else {
-		index = pos
-		for i := uint64(0); i < wm.alphabetBitNum; i++ {
-			bit := (c >> (wm.alphabetBitNum - i + uint64(1))) & 1
-			b := toBool(bit)
-			index, _ = wm.bv[i].Rank(index, b)
-			if b {
-				index += wm.nodePos[i][1]
-			}
-		}
-	}
+	return wm.prevPos(c, index+rank-uint64(1))
+}
 
-	index += rank
+// nextPos maps pos in level i to the position in level i+1.
+func (wm *WMData) nextPos(i, pos uint64, b bool) uint64 {
+	next, _ := wm.bv[i].Rank(pos, b)
+	if b {
+		next += wm.zeros[i]
+	}
+	return next
+}
 
+// prevPos maps index in the bottom level, where the element is `c`, to the position in the array.
+func (wm *WMData) prevPos(c, index uint64) (uint64, bool) {
 	for i := int(wm.alphabetBitNum) - 1; i >= 0; i-- {
-		bit := (c >> (wm.alphabetBitNum - uint64(i) - uint64(1))) & 1
-		b := toBool(bit)
+		b := wm.bitOf(c, uint64(i))
 		if b {
-			index -= wm.nodePos[i][1]
+			index -= wm.zeros[i]
 		}
 		var err error
-		index, err = wm.bv[i].Select(index-uint64(1), b)
+		index, err = wm.bv[i].Select(index, b)
 		if err != nil {
 			return NotFound, false
 		}
-		index++
-	}
-	return index - uint64(1), true
-}
-
-// Freq returns the frequency of the character `c`.
-func (wm *WMData) Freq(c uint64) uint64 {
-	rank, _ := wm.Rank(c, wm.size)
-	return rank
-}
-
-// FreqSum returns frequency of the characters(minC <= c' < maxC)
-func (wm *WMData) FreqSum(minC, maxC uint64) uint64 {
-	sum := uint64(0)
-	for i := minC; i < maxC; i++ {
-		sum += wm.Freq(i)
 	}
-	return sum
+	return index, true
 }
 
-// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
-func (wm *WMData) FreqRange(minC, maxC, begPos, endPos uint64) uint64 {
-	if minC >= wm.alphabetNum {
-		return uint64(0)
-	}
-	if maxC <= minC {
-		return uint64(0)
-	}
-	if endPos > wm.size || begPos >= endPos {
-		return uint64(0)
-	}
-	_, maxLess, _ := wm.RankAll(maxC, begPos, endPos)
-	_, minLess, _ := wm.RankAll(minC, begPos, endPos)
-	return maxLess - minLess
+// bitOf returns the bit of `c` which is used in level i.
+func (wm *WMData) bitOf(c, i uint64) bool {
+	return toBool((c >> (wm.alphabetBitNum - i - uint64(1))) & uint64(1))
 }
 
-// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
-func (wm *WMData) QuantileRange(begPos, endPos, k uint64) (pos, val uint64) {
-	if endPos >= wm.size || begPos >= endPos || k >= (endPos-begPos) {
-		pos = NotFound
-		val = NotFound
-		return
-	}
-
+// quantileRange returns the K-th smallest value( and position) in A[begPos ... endPos) without argument checks.
+func (wm *WMData) quantileRange(begPos, endPos, k uint64) (pos, val uint64) {
 	val = 0
 
-	nodeNum := uint64(0)
-	begZero := uint64(0)
-	endZero := uint64(0)
-
-	fromZero := (begPos == 0)
-	toEnd := (endPos == wm.size)
-
 	for i := uint64(0); i < wm.alphabetBitNum; i++ {
 		bv := wm.bv[i]
-
-		if fromZero {
-			begZero = wm.nodePos[i][nodeNum]
-		} else {
-			begZero, _ = bv.Rank0(begPos)
-		}
-
-		if toEnd {
-			endZero = wm.nodePos[i][nodeNum+uint64(1)]
-		} else {
-			endZero, _ = bv.Rank0(endPos)
-		}
+		begZero, _ := bv.Rank0(begPos)
+		endZero, _ := bv.Rank0(endPos)
 
 		zeroBits := endZero - begZero
 		bit := uint64(1)
@@ -334,41 +387,29 @@ func (wm *WMData) QuantileRange(begPos, endPos, k uint64) (pos, val uint64) {
 		}
 		if bit == uint64(1) {
 			k -= zeroBits
-			begPos += wm.nodePos[i][1] - begZero
-			endPos += wm.nodePos[i][1] - endZero
+			begPos = wm.zeros[i] + begPos - begZero
+			endPos = wm.zeros[i] + endPos - endZero
 		} else {
 			begPos = begZero
 			endPos = endZero
 		}
 
-		nodeNum |= bit << i
 		val <<= 1
 		val |= bit
 	}
-	pos, _ = wm.Select(val, begPos+k-wm.nodePos[wm.alphabetBitNum-uint64(1)][val]+uint64(1))
-	return
-}
-
-// MaxRange returns maximum value(and position) in the subarray A[begPos .. endPos]
-func (wm *WMData) MaxRange(begPos, endPos uint64) (pos, val uint64) {
-	pos, val = wm.QuantileRange(begPos, endPos, endPos-begPos-uint64(1))
-	return
-}
-
-// MinRange returns minimum value(and position) in the subarray A[begPos .. endPos]
-func (wm *WMData) MinRange(begPos, endPos uint64) (pos, val uint64) {
-	pos, val = wm.QuantileRange(begPos, endPos, 0)
+	pos, _ = wm.prevPos(val, begPos+k)
 	return
 }
 
+// listRange lists the distinct characters minC <= c < maxC in A[begPos ... endPos) in the order of comparator without argument checks.
 func (wm *WMData) listRange(minC, maxC, begPos, endPos, num uint64, comparator pq.CmpFunc) []ListResult {
 	var res []ListResult
-	if endPos > wm.size || begPos >= endPos {
+	if begPos >= endPos || minC >= maxC {
 		return res
 	}
 
 	q := pq.NewPriorityQueue(comparator)
-	q.Push(&queryOnNode{0, wm.size, begPos, endPos, 0, 0})
+	q.Push(&queryOnNode{begPos, endPos, 0, 0})
 	for uint64(len(res)) < num && !q.Empty() {
 		qon := q.Pop().(*queryOnNode)
 		if qon.depth >= wm.alphabetBitNum {
@@ -384,43 +425,25 @@ func (wm *WMData) listRange(minC, maxC, begPos, endPos, num uint64, comparator p
 	return res
 }
 
-// ListModeRange returns list of the distinct characters appeared in A[begPos ... endPos) from most frequent ones.
-func (wm *WMData) ListModeRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
-	return wm.listRange(minC, maxC, begPos, endPos, num, modeComparator)
-}
-
-// ListMinRange returns list of the distinct characters in A[begPos ... endPos) minC <= c < maxC  from smallest ones.
-func (wm *WMData) ListMinRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
-	return wm.listRange(minC, maxC, begPos, endPos, num, minComparator)
-}
-
-// ListMaxRange returns list of the distinct characters appeared in A[begPos ... endPos) from largest ones.
-func (wm *WMData) ListMaxRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
-	return wm.listRange(minC, maxC, begPos, endPos, num, maxComparator)
-}
-
 func (wm *WMData) expandNode(minC, maxC uint64, qon *queryOnNode) []*queryOnNode {
 	bv := wm.bv[qon.depth]
-	begNodeZero, _ := bv.Rank0(qon.begNode)
-	endNodeZero, _ := bv.Rank0(qon.endNode)
-	begNodeOne := qon.begNode - begNodeZero
 	begZero, _ := bv.Rank0(qon.begPos)
 	endZero, _ := bv.Rank0(qon.endPos)
 	begOne := qon.begPos - begZero
 	endOne := qon.endPos - endZero
-	boundary := qon.begNode + endNodeZero - begNodeZero
 	var next []*queryOnNode
 	if (endZero - begZero) > 0 {
 		nextPrefix := qon.prefixChar << 1
 		if wm.checkPrefix(nextPrefix, qon.depth+1, minC, maxC) {
-			next = append(next, &queryOnNode{qon.begNode, boundary, qon.begNode + begZero - begNodeZero, qon.begNode + endZero - begNodeZero, qon.depth + 1, nextPrefix})
+			next = append(next, &queryOnNode{begZero, endZero, qon.depth + 1, nextPrefix})
 		}
 
 	}
 	if (endOne - begOne) > 0 {
 		nextPrefix := (qon.prefixChar << 1) + uint64(1)
 		if wm.checkPrefix(nextPrefix, qon.depth+1, minC, maxC) {
-			next = append(next, &queryOnNode{boundary, qon.endNode, boundary + begOne - begNodeOne, boundary + endOne - begNodeOne, qon.depth + 1, nextPrefix})
+			zeros := wm.zeros[qon.depth]
+			next = append(next, &queryOnNode{zeros + begOne, zeros + endOne, qon.depth + 1, nextPrefix})
 		}
 	}
 	return next
@@ -437,136 +460,24 @@ func (wm *WMData) checkPrefix(prefix, depth, minC, maxC uint64) bool {
 	return false
 }
 
-
 /*
 MarshalBinary implements the encoding.BinaryMarshaler interface.
 */
 func (wm *WMData) MarshalBinary() ([]byte, error) {
 	buffer := new(bytes.Buffer)
-	binary.Write(buffer, binary.LittleEndian, &wm.size)
-	binary.Write(buffer, binary.LittleEndian, &wm.alphabetNum)
-	binary.Write(buffer, binary.LittleEndian, &wm.alphabetBitNum)
-	bvSize := uint64(len(wm.bv))
-	binary.Write(buffer, binary.LittleEndian, &bvSize)
-	for i := uint64(0); i < bvSize; i++ {
-		buf, _ := wm.bv[i].MarshalBinary()
-		vsize := uint64(len(buf))
-		binary.Write(buffer, binary.LittleEndian, &vsize)
-		binary.Write(buffer, binary.LittleEndian, buf)
-	}
-	npSize := uint64(len(wm.nodePos))
-	binary.Write(buffer, binary.LittleEndian, &npSize)
-	for i := uint64(0); i < npSize; i++ {
-		arraySize := uint64(len(wm.nodePos[i]))
-		binary.Write(buffer, binary.LittleEndian, &arraySize)
-		for j := uint64(0); j < arraySize; j++ {
-			binary.Write(buffer, binary.LittleEndian, &(wm.nodePos[i][j]))
-		}
+	if _, err := wm.WriteTo(buffer); err != nil {
+		return nil, err
 	}
-	sepSize := uint64(len(wm.seps))
-	binary.Write(buffer, binary.LittleEndian, &sepSize)
-	for i := uint64(0); i < sepSize; i++ {
-		binary.Write(buffer, binary.LittleEndian, &(wm.seps[i]))
-	}
-
 	return buffer.Bytes(), nil
 }
 
 /*
 UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+Both the current format and the legacy format(which holds node position tables) are accepted.
 */
 func (wm *WMData) UnmarshalBinary(data []byte) error {
-	dataLen := uint64(len(data))
-	offset := uint64(0)
-	if dataLen < offset+sizeOfInt64 {
-		return ErrorInvalidFormat
-	}
-	buf := data[offset : offset+sizeOfInt64]
-	offset += sizeOfInt64
-	wm.size = binary.LittleEndian.Uint64(buf)
-
-	if dataLen < offset+sizeOfInt64 {
-		return ErrorInvalidFormat
-	}
-	buf = data[offset : offset+sizeOfInt64]
-	offset += sizeOfInt64
-	wm.alphabetNum = binary.LittleEndian.Uint64(buf)
-
-	if dataLen < offset+sizeOfInt64 {
-		return ErrorInvalidFormat
-	}
-	buf = data[offset : offset+sizeOfInt64]
-	offset += sizeOfInt64
-	wm.alphabetBitNum = binary.LittleEndian.Uint64(buf)
-
-	if dataLen < offset+sizeOfInt64 {
-		return ErrorInvalidFormat
-	}
-	buf = data[offset : offset+sizeOfInt64]
-	offset += sizeOfInt64
-	bvSize := binary.LittleEndian.Uint64(buf)
-	wm.bv = make([]*sbvector.BitVectorData, bvSize)
-
-	for i := uint64(0); i < bvSize; i++ {
-		if dataLen < offset+sizeOfInt64 {
-			return ErrorInvalidFormat
-		}
-		buf := data[offset : offset+sizeOfInt64]
-		offset += sizeOfInt64
-		vsize := binary.LittleEndian.Uint64(buf)
-
-		if dataLen < offset+vsize {
-			return ErrorInvalidFormat
-		}
-		buf = data[offset : offset+vsize]
-		bv, err := sbvector.NewVectorFromBinary(buf)
-		if err != nil {
-			return ErrorInvalidFormat
-		}
-		wm.bv[i] = bv.(*sbvector.BitVectorData)
-		offset += vsize
-	}
-	if dataLen < offset+sizeOfInt64 {
-		return ErrorInvalidFormat
-	}
-	buf = data[offset : offset+sizeOfInt64]
-	offset += sizeOfInt64
-	npSize := binary.LittleEndian.Uint64(buf)
-	wm.nodePos = make([][]uint64, npSize)
-	for i := uint64(0); i < npSize; i++ {
-		if dataLen < offset+sizeOfInt64 {
-			return ErrorInvalidFormat
-		}
-		buf := data[offset : offset+sizeOfInt64]
-		offset += sizeOfInt64
-		arrayLen := binary.LittleEndian.Uint64(buf)
-		if dataLen < offset+sizeOfInt64*arrayLen {
-			return ErrorInvalidFormat
-		}
-		wm.nodePos[i] = make([]uint64, arrayLen)
-		for j := uint64(0); j < arrayLen; j++ {
-			buf := data[offset : offset+sizeOfInt64]
-			wm.nodePos[i][j] = binary.LittleEndian.Uint64(buf)
-			offset += sizeOfInt64
-		}
-	}
-	if dataLen < offset+sizeOfInt64 {
-		return ErrorInvalidFormat
-	}
-	buf = data[offset : offset+sizeOfInt64]
-	offset += sizeOfInt64
-	sepSize := binary.LittleEndian.Uint64(buf)
-	if dataLen < offset+sepSize*sizeOfInt64 {
-		return ErrorInvalidFormat
-	}
-	wm.seps = make([]uint64, sepSize)
-	for i := uint64(0); i < sepSize; i++ {
-		buf := data[offset : offset+sizeOfInt64]
-		wm.seps[i] = binary.LittleEndian.Uint64(buf)
-		offset += sizeOfInt64
-	}
-
-	return nil
+	_, err := wm.ReadFrom(bytes.NewReader(data))
+	return err
 }
 
 func toBool(bit uint64) bool {
@@ -584,7 +495,7 @@ func modeComparator(a, b interface{}) bool {
 	} else if lhs.depth != rhs.depth {
 		return lhs.depth < rhs.depth
 	} else {
-		return lhs.begPos > rhs.begPos
+		return lhs.prefixChar > rhs.prefixChar
 	}
 }
 
@@ -594,7 +505,7 @@ func minComparator(a, b interface{}) bool {
 	if lhs.depth != rhs.depth {
 		return lhs.depth < rhs.depth
 	}
-	return lhs.begPos > rhs.begPos
+	return lhs.prefixChar > rhs.prefixChar
 
 }
 
@@ -604,5 +515,5 @@ func maxComparator(a, b interface{}) bool {
 	if lhs.depth != rhs.depth {
 		return lhs.depth < rhs.depth
 	}
-	return lhs.begPos < rhs.begPos
+	return lhs.prefixChar < rhs.prefixChar
 }
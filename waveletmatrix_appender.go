@@ -0,0 +1,481 @@
+package waveletmatrix
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/hideo55/go-pq"
+)
+
+// Appender is the sequence which grows only at the end, and answers the queries as Wavelet-Matrix.
+// The appended values are kept in the mutable tail until it becomes full, and the full tail is sealed into
+// an immutable Wavelet-Matrix segment. Adjacent segments are merged in the background, so that each segment is
+// at least twice as large as the next one, and the number of segments is O(log n).
+// The queries are answered across the segments and the tail as if they were one sequence.
+// Appender is safe for concurrent use.
+type Appender struct {
+	mu       sync.RWMutex
+	segments []appenderSegment
+	tail     []uint64
+	tailSize uint64
+	size     uint64
+	// maxValue is the maximum value appended so far.
+	maxValue uint64
+	opts     []Option
+	// merging is true while the goroutine merging the segments is running.
+	merging bool
+	wg      sync.WaitGroup
+	err     error
+}
+
+type appenderSegment struct {
+	wm     *WMData
+	begPos uint64
+}
+
+var (
+	// ErrorTailSize indicates that the size of the tail of Appender is 0.
+	ErrorTailSize = errors.New("Size of tail must be greater than 0.")
+)
+
+// NewAppender returns the empty Appender whose tail holds tailSize values.
+// opts are applied to build the segments.
+func NewAppender(tailSize uint64, opts ...Option) (*Appender, error) {
+	if tailSize == 0 {
+		return nil, ErrorTailSize
+	}
+	return &Appender{tailSize: tailSize, opts: opts, tail: make([]uint64, 0, tailSize)}, nil
+}
+
+// Append appends values at the end of the sequence.
+// If building a segment fails, the error is returned, and the values are not appended.
+// The error of the background merge is also reported by the following Append.
+func (a *Appender) Append(values ...uint64) error {
+	for _, c := range values {
+		if c == NotFound {
+			return ErrorReservedValue
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err != nil {
+		return a.err
+	}
+	tail := append(a.tail, values...)
+	var sealed []appenderSegment
+	begPos := a.size - uint64(len(a.tail))
+	for uint64(len(tail)) >= a.tailSize {
+		wm, err := newWMBuilder(a.opts).Build(tail[:a.tailSize])
+		if err != nil {
+			return err
+		}
+		sealed = append(sealed, appenderSegment{wm.(*WMData), begPos})
+		begPos += a.tailSize
+		tail = tail[a.tailSize:]
+	}
+	for _, c := range values {
+		if c > a.maxValue {
+			a.maxValue = c
+		}
+	}
+	a.size += uint64(len(values))
+	a.segments = append(a.segments, sealed...)
+	a.tail = append(make([]uint64, 0, a.tailSize), tail...)
+	if len(sealed) > 0 && !a.merging {
+		a.merging = true
+		a.wg.Add(1)
+		go a.merge()
+	}
+	return nil
+}
+
+// Wait waits until the background merge of the segments finishes.
+// It returns the error of the merge, if any.
+func (a *Appender) Wait() error {
+	a.wg.Wait()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.err
+}
+
+// merge merges adjacent segments until each segment is at least twice as large as the next one.
+// Only this goroutine removes segments, and Append only adds segments at the end, so that the indexes of
+// the merged segments are not changed while they are merged without the lock.
+func (a *Appender) merge() {
+	defer a.wg.Done()
+	for {
+		a.mu.Lock()
+		i := len(a.segments) - 2
+		for ; i >= 0; i-- {
+			if a.segments[i].wm.size < 2*a.segments[i+1].wm.size {
+				break
+			}
+		}
+		if i < 0 || a.err != nil {
+			a.merging = false
+			a.mu.Unlock()
+			return
+		}
+		lhs, rhs := a.segments[i], a.segments[i+1]
+		a.mu.Unlock()
+
+		values := make([]uint64, 0, lhs.wm.size+rhs.wm.size)
+		for _, seg := range []appenderSegment{lhs, rhs} {
+			for pos := uint64(0); pos < seg.wm.size; pos++ {
+				values = append(values, seg.wm.lookup(pos))
+			}
+		}
+		wm, err := newWMBuilder(a.opts).build(values)
+
+		a.mu.Lock()
+		if err != nil {
+			a.err = err
+		} else {
+			a.segments[i] = appenderSegment{wm.(*WMData), lhs.begPos}
+			a.segments = append(a.segments[:i+1], a.segments[i+2:]...)
+		}
+		a.mu.Unlock()
+	}
+}
+
+// Size returns the number of the appended values.
+func (a *Appender) Size() uint64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.size
+}
+
+// NumOfSegments returns the number of the sealed segments.
+func (a *Appender) NumOfSegments() uint64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return uint64(len(a.segments))
+}
+
+// Lookup returns value of pos-th element.
+// if pos >= (size of the sequence),  value of second result parameter is false.
+func (a *Appender) Lookup(pos uint64) (uint64, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if pos >= a.size {
+		return NotFound, false
+	}
+	c := NotFound
+	a.forEachPart(pos, pos+1, func(wm *WMData, tail []uint64, begPos, endPos uint64) {
+		if wm != nil {
+			c = wm.lookup(begPos)
+		} else {
+			c = tail[begPos]
+		}
+	})
+	return c, true
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the sequence A[0...pos)
+func (a *Appender) Rank(c, pos uint64) (uint64, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if pos > a.size {
+		return NotFound, false
+	}
+	return a.rank(c, 0, pos), true
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the sequence.
+func (a *Appender) Select(c, rank uint64) (uint64, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.selectFromPos(c, rank)
+}
+
+// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
+func (a *Appender) FreqRange(minC, maxC, begPos, endPos uint64) uint64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if checkListRange(minC, maxC, begPos, endPos, a.size) != nil {
+		return 0
+	}
+	return a.rankLessThan(maxC, begPos, endPos) - a.rankLessThan(minC, begPos, endPos)
+}
+
+// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
+// The levels of all the segments are descended at once, summing the number of 0 bits of the ranges in each level.
+func (a *Appender) QuantileRange(begPos, endPos, k uint64) (pos, val uint64) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if checkPosRange(begPos, endPos, a.size) != nil || k >= endPos-begPos {
+		return NotFound, NotFound
+	}
+	bitNum := a.bitNum()
+	node := a.rootNode(begPos, endPos)
+	for node.depth < bitNum {
+		zero, one := a.expandNode(node, bitNum)
+		if k < zero.freq {
+			node = zero
+		} else {
+			k -= zero.freq
+			node = one
+		}
+	}
+	val = node.prefix
+	// The elements of the same value are ordered by their positions.
+	pos, _ = a.selectFromPos(val, a.rank(val, 0, begPos)+k+1)
+	return
+}
+
+// ListModeRange returns list of the distinct characters appeared in A[begPos ... endPos) from most frequent ones.
+// The characters of the same frequency are listed from smallest ones.
+func (a *Appender) ListModeRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return a.listRange(minC, maxC, begPos, endPos, num, func(lhs, rhs *appenderNode, bitNum uint64) bool {
+		if lhs.freq != rhs.freq {
+			return lhs.freq < rhs.freq
+		}
+		if l, r := lhs.minValue(bitNum), rhs.minValue(bitNum); l != r {
+			return l > r
+		}
+		return lhs.depth < rhs.depth
+	})
+}
+
+// ListMinRange returns list of the distinct characters in A[begPos ... endPos) minC <= c < maxC  from smallest ones.
+func (a *Appender) ListMinRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return a.listRange(minC, maxC, begPos, endPos, num, func(lhs, rhs *appenderNode, bitNum uint64) bool {
+		if l, r := lhs.minValue(bitNum), rhs.minValue(bitNum); l != r {
+			return l > r
+		}
+		return lhs.depth < rhs.depth
+	})
+}
+
+// ListMaxRange returns list of the distinct characters appeared in A[begPos ... endPos) from largest ones.
+func (a *Appender) ListMaxRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return a.listRange(minC, maxC, begPos, endPos, num, func(lhs, rhs *appenderNode, bitNum uint64) bool {
+		if l, r := lhs.maxValue(bitNum), rhs.maxValue(bitNum); l != r {
+			return l < r
+		}
+		return lhs.depth < rhs.depth
+	})
+}
+
+// appenderNode is the node of the values of prefix in the top depth bits, across all the segments and the tail.
+type appenderNode struct {
+	freq   uint64
+	depth  uint64
+	prefix uint64
+	// ranges holds the range of the node in each segment.
+	ranges []appenderRange
+	// tail holds the values of the node in the tail.
+	tail []uint64
+}
+
+type appenderRange struct {
+	wm     *WMData
+	begPos uint64
+	endPos uint64
+}
+
+// listRange lists the distinct characters minC <= c < maxC in the order of less. The nodes of all the segments are
+// traversed at once by a priority queue, so that only the first num characters are produced.
+func (a *Appender) listRange(minC, maxC, begPos, endPos, num uint64, less func(lhs, rhs *appenderNode, bitNum uint64) bool) []ListResult {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if checkListRange(minC, maxC, begPos, endPos, a.size) != nil {
+		return nil
+	}
+	var res []ListResult
+	if begPos == endPos || minC == maxC {
+		return res
+	}
+	bitNum := a.bitNum()
+	q := pq.NewPriorityQueue(func(lhs, rhs interface{}) bool {
+		return less(lhs.(*appenderNode), rhs.(*appenderNode), bitNum)
+	})
+	root := a.rootNode(begPos, endPos)
+	if root.minValue(bitNum) < maxC && root.maxValue(bitNum) >= minC {
+		q.Push(root)
+	}
+	for uint64(len(res)) < num && !q.Empty() {
+		node := q.Pop().(*appenderNode)
+		if node.depth >= bitNum {
+			res = append(res, ListResult{node.prefix, node.freq})
+			continue
+		}
+		zero, one := a.expandNode(node, bitNum)
+		for _, child := range [...]*appenderNode{zero, one} {
+			if child.freq > 0 && child.minValue(bitNum) < maxC && child.maxValue(bitNum) >= minC {
+				q.Push(child)
+			}
+		}
+	}
+	return res
+}
+
+// bitNum returns the number of bits of the values, which is at least the number of levels of every segment.
+func (a *Appender) bitNum() uint64 {
+	bitNum := log2(a.maxValue + 1)
+	for _, seg := range a.segments {
+		if seg.wm.alphabetBitNum > bitNum {
+			bitNum = seg.wm.alphabetBitNum
+		}
+	}
+	return bitNum
+}
+
+// rootNode returns the node of all the values in A[begPos ... endPos).
+func (a *Appender) rootNode(begPos, endPos uint64) *appenderNode {
+	root := &appenderNode{freq: endPos - begPos}
+	a.forEachPart(begPos, endPos, func(wm *WMData, tail []uint64, begPos, endPos uint64) {
+		if wm != nil {
+			root.ranges = append(root.ranges, appenderRange{wm, begPos, endPos})
+		} else {
+			root.tail = tail[begPos:endPos]
+		}
+	})
+	return root
+}
+
+// expandNode returns the children of node, whose next bit is 0 and 1. The segment of fewer levels than bitNum
+// is treated as having the levels of 0 bits at the top.
+func (a *Appender) expandNode(node *appenderNode, bitNum uint64) (zero, one *appenderNode) {
+	zero = &appenderNode{depth: node.depth + 1, prefix: node.prefix << 1}
+	one = &appenderNode{depth: node.depth + 1, prefix: node.prefix<<1 | 1}
+	for _, r := range node.ranges {
+		skip := bitNum - r.wm.alphabetBitNum
+		if node.depth < skip {
+			zero.ranges = append(zero.ranges, r)
+			zero.freq += r.endPos - r.begPos
+			continue
+		}
+		i := node.depth - skip
+		begZero, _ := r.wm.bv[i].Rank0(r.begPos)
+		endZero, _ := r.wm.bv[i].Rank0(r.endPos)
+		if endZero > begZero {
+			zero.ranges = append(zero.ranges, appenderRange{r.wm, begZero, endZero})
+			zero.freq += endZero - begZero
+		}
+		begOne := r.wm.zeros[i] + r.begPos - begZero
+		endOne := r.wm.zeros[i] + r.endPos - endZero
+		if endOne > begOne {
+			one.ranges = append(one.ranges, appenderRange{r.wm, begOne, endOne})
+			one.freq += endOne - begOne
+		}
+	}
+	shift := bitNum - node.depth - 1
+	for _, c := range node.tail {
+		if (c>>shift)&1 == 0 {
+			zero.tail = append(zero.tail, c)
+			zero.freq++
+		} else {
+			one.tail = append(one.tail, c)
+			one.freq++
+		}
+	}
+	return
+}
+
+// minValue returns the smallest value of the node.
+func (n *appenderNode) minValue(bitNum uint64) uint64 {
+	if bitNum-n.depth >= 64 {
+		return 0
+	}
+	return n.prefix << (bitNum - n.depth)
+}
+
+// maxValue returns the largest value of the node.
+func (n *appenderNode) maxValue(bitNum uint64) uint64 {
+	if bitNum-n.depth >= 64 {
+		return NotFound
+	}
+	return (n.prefix+1)<<(bitNum-n.depth) - 1
+}
+
+// forEachPart calls f for each segment and the tail overlapping A[begPos ... endPos), with the range relative to it.
+// wm is nil for the tail.
+func (a *Appender) forEachPart(begPos, endPos uint64, f func(wm *WMData, tail []uint64, begPos, endPos uint64)) {
+	i := sort.Search(len(a.segments), func(i int) bool {
+		return a.segments[i].begPos+a.segments[i].wm.size > begPos
+	})
+	for ; i < len(a.segments) && a.segments[i].begPos < endPos; i++ {
+		seg := a.segments[i]
+		b, e := uint64(0), seg.wm.size
+		if begPos > seg.begPos {
+			b = begPos - seg.begPos
+		}
+		if endPos < seg.begPos+e {
+			e = endPos - seg.begPos
+		}
+		f(seg.wm, nil, b, e)
+	}
+	tailPos := a.size - uint64(len(a.tail))
+	if endPos > tailPos {
+		b := uint64(0)
+		if begPos > tailPos {
+			b = begPos - tailPos
+		}
+		f(nil, a.tail, b, endPos-tailPos)
+	}
+}
+
+// rank returns the frequency of `c` in A[begPos...endPos).
+func (a *Appender) rank(c, begPos, endPos uint64) uint64 {
+	rank := uint64(0)
+	a.forEachPart(begPos, endPos, func(wm *WMData, tail []uint64, begPos, endPos uint64) {
+		if wm != nil {
+			if c < wm.alphabetNum {
+				rank += wm.rank(c, begPos, endPos)
+			}
+			return
+		}
+		for _, v := range tail[begPos:endPos] {
+			if v == c {
+				rank++
+			}
+		}
+	})
+	return rank
+}
+
+// rankLessThan returns the frequency of characters c' < c in A[begPos...endPos).
+func (a *Appender) rankLessThan(c, begPos, endPos uint64) uint64 {
+	rank := uint64(0)
+	a.forEachPart(begPos, endPos, func(wm *WMData, tail []uint64, begPos, endPos uint64) {
+		if wm != nil {
+			rank += wm.rankLessThan(c, begPos, endPos)
+			return
+		}
+		for _, v := range tail[begPos:endPos] {
+			if v < c {
+				rank++
+			}
+		}
+	})
+	return rank
+}
+
+// selectFromPos returns the position of the rank-th occurrence of `c`.
+func (a *Appender) selectFromPos(c, rank uint64) (uint64, bool) {
+	if rank == 0 {
+		return NotFound, false
+	}
+	for _, seg := range a.segments {
+		if c >= seg.wm.alphabetNum {
+			continue
+		}
+		freq := seg.wm.rank(c, 0, seg.wm.size)
+		if rank <= freq {
+			pos, found := seg.wm.selectFromPos(c, 0, rank)
+			return seg.begPos + pos, found
+		}
+		rank -= freq
+	}
+	tailPos := a.size - uint64(len(a.tail))
+	for i, v := range a.tail {
+		if v == c {
+			if rank--; rank == 0 {
+				return tailPos + uint64(i), true
+			}
+		}
+	}
+	return NotFound, false
+}
@@ -0,0 +1,132 @@
+package waveletmatrix
+
+import (
+	"errors"
+
+	"github.com/hideo55/go-sbvector"
+)
+
+// bitVector is the bit vector of a level of Wavelet-Matrix.
+// Rank(i, b) returns the number of b in [0, i), and Select(x, b) returns the position of the (x+1)-th b.
+type bitVector interface {
+	Size() uint64
+	NumOfBits(b bool) uint64
+	Get(i uint64) (bool, error)
+	Rank(i uint64, b bool) (uint64, error)
+	Rank0(i uint64) (uint64, error)
+	Rank1(i uint64) (uint64, error)
+	Select(x uint64, b bool) (uint64, error)
+	MarshalBinary() ([]byte, error)
+}
+
+// bitVectorBuilder builds bitVector. The bits are set in ascending order of the position.
+type bitVectorBuilder interface {
+	Set(i uint64, b bool)
+	Build() (bitVector, error)
+}
+
+// bitVectorBackend creates and restores the bit vectors of the levels.
+// The backends are internal to this package, and are selected by BitVectorKind given to WithBitVectorBackend.
+// The backend used to build Wavelet-Matrix is recorded in the binary by its name.
+type bitVectorBackend interface {
+	Name() string
+	// NewBuilder returns the builder of a bit vector. If selectIndex is true, the indexes to accelerate Select should be built.
+	NewBuilder(selectIndex bool) bitVectorBuilder
+	// UnmarshalBitVector restores the bit vector from the binary created by its MarshalBinary.
+	UnmarshalBitVector(data []byte) (bitVector, error)
+}
+
+// sbvectorBackend is the default backend, which uses go-sbvector.
+type sbvectorBackend struct{}
+
+type sbvectorBuilder struct {
+	builder     sbvector.SuccinctBitVectorBuilder
+	selectIndex bool
+}
+
+var (
+	// ErrorInvalidBackendName indicates that the name of the bit vector backend is too long to be recorded.
+	ErrorInvalidBackendName = errors.New("Name of bit vector backend must be at most 255 bytes.")
+
+	// defaultBitVectorBackend is the backend used if WithBitVectorBackend is not specified.
+	defaultBitVectorBackend bitVectorBackend = sbvectorBackend{}
+
+	// bitVectorBackends are the backends which can be restored from the binary by their names.
+	bitVectorBackends = []bitVectorBackend{defaultBitVectorBackend, rrrBitVectorBackend, dynamicBitVectorBackend}
+)
+
+// BitVectorKind is the kind of the bit vectors of the levels, which is specified by WithBitVectorBackend.
+type BitVectorKind int
+
+const (
+	// DefaultBitVectorBackend is the succinct bit vector of go-sbvector, which is used if WithBitVectorBackend is not specified.
+	DefaultBitVectorBackend BitVectorKind = iota
+	// RRRBitVectorBackend is RRR-compressed bit vector, for the arrays whose values are skewed.
+	// Rank, Select and Lookup are slower than DefaultBitVectorBackend by a constant factor. The binary records it.
+	RRRBitVectorBackend
+)
+
+// levelBackend returns the backend of the bit vectors of wm.
+func (wm *WMData) levelBackend() bitVectorBackend {
+	if wm.backend == nil {
+		return defaultBitVectorBackend
+	}
+	return wm.backend
+}
+
+func lookupBitVectorBackend(name string) (bitVectorBackend, bool) {
+	for _, backend := range bitVectorBackends {
+		if backend.Name() == name {
+			return backend, true
+		}
+	}
+	return nil, false
+}
+
+// WithBitVectorBackend specifies the kind of the bit vectors of the levels(default: DefaultBitVectorBackend).
+// An unknown kind is treated as DefaultBitVectorBackend.
+func WithBitVectorBackend(kind BitVectorKind) Option {
+	return withBackend(kind.backend())
+}
+
+// withBackend specifies the backend of the bit vectors of the levels.
+func withBackend(backend bitVectorBackend) Option {
+	return func(builder *wmBuilderData) {
+		builder.backend = backend
+	}
+}
+
+func (kind BitVectorKind) backend() bitVectorBackend {
+	if kind == RRRBitVectorBackend {
+		return rrrBitVectorBackend
+	}
+	return defaultBitVectorBackend
+}
+
+func (sbvectorBackend) Name() string {
+	return "sbvector"
+}
+
+func (sbvectorBackend) NewBuilder(selectIndex bool) bitVectorBuilder {
+	return &sbvectorBuilder{sbvector.NewVectorBuilder(), selectIndex}
+}
+
+func (sbvectorBackend) UnmarshalBitVector(data []byte) (bitVector, error) {
+	bv, err := sbvector.NewVectorFromBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	return bv, nil
+}
+
+func (b *sbvectorBuilder) Set(i uint64, bit bool) {
+	b.builder.Set(i, bit)
+}
+
+func (b *sbvectorBuilder) Build() (bitVector, error) {
+	bv, err := b.builder.Build(b.selectIndex, b.selectIndex)
+	if err != nil {
+		return nil, err
+	}
+	return bv, nil
+}
@@ -2,11 +2,55 @@ package waveletmatrix
 
 import (
 	"errors"
-	"github.com/hideo55/go-sbvector"
 )
 
 type wmBuilderData struct {
 	wm *WMData
+	// workers is the number of goroutines used to build levels. If it is less than 2, levels are built sequentially.
+	workers int
+	// alphabetNum is the declared alphabet size. If it is 0, the alphabet size is derived from the array.
+	alphabetNum uint64
+	// selectIndex indicates whether the indexes to accelerate select are built.
+	selectIndex bool
+	// weights holds the weight of each element. If it is nil, the cumulative weights are not built.
+	weights []uint64
+	// backend creates the bit vectors of the levels.
+	backend bitVectorBackend
+}
+
+// Option configures the construction of Wavelet-Matrix.
+type Option func(builder *wmBuilderData)
+
+// WithAlphabetSize fixes the alphabet size to alphabetNum instead of deriving it from the array.
+// Wavelet-Matrices built with the same alphabet size share the same encoding of the characters.
+// Build fails with ErrorOutOfAlphabet if the array contains a value not less than alphabetNum.
+func WithAlphabetSize(alphabetNum uint64) Option {
+	return func(builder *wmBuilderData) {
+		builder.alphabetNum = alphabetNum
+	}
+}
+
+// WithSelectIndex specifies whether the indexes to accelerate Select are built(default: true).
+// Without them, Select and SelectFromPos are slower but Wavelet-Matrix uses less memory.
+func WithSelectIndex(enabled bool) Option {
+	return func(builder *wmBuilderData) {
+		builder.selectIndex = enabled
+	}
+}
+
+// WithWorkers specifies the number of goroutines used to build levels(default: 1).
+func WithWorkers(workers int) Option {
+	return func(builder *wmBuilderData) {
+		builder.workers = workers
+	}
+}
+
+func newWMBuilder(opts []Option) *wmBuilderData {
+	builder := &wmBuilderData{selectIndex: true, backend: defaultBitVectorBackend}
+	for _, opt := range opts {
+		opt(builder)
+	}
+	return builder
 }
 
 type wmBuilder interface {
@@ -15,89 +59,133 @@ type wmBuilder interface {
 
 var (
 	ErrorEmpty = errors.New("Argument is empty.")
+	// ErrorReservedValue indicates that the array contains NotFound, which can not be stored.
+	ErrorReservedValue = errors.New("Argument contains the reserved value NotFound.")
+	// ErrorOutOfAlphabet indicates that the value is not less than the declared alphabet size.
+	ErrorOutOfAlphabet = errors.New("Value is out of the alphabet.")
 )
 
 func NewWM(src []uint64) (WaveletMatrix, error) {
-	builder := &wmBuilderData{}
-	builder.wm = &WMData{}
+	return NewWMWithOptions(src)
+}
+
+// NewWMWithOptions builds Wavelet-Matrix configured by opts.
+func NewWMWithOptions(src []uint64, opts ...Option) (WaveletMatrix, error) {
+	builder := newWMBuilder(opts)
 	return builder.Build(src)
 }
 
+// NewWMParallel builds Wavelet-Matrix using `workers` goroutines.
+// The result is identical to the one of NewWM.
+func NewWMParallel(src []uint64, workers int) (WaveletMatrix, error) {
+	return NewWMWithOptions(src, WithWorkers(workers))
+}
+
 func (builder *wmBuilderData) Build(src []uint64) (WaveletMatrix, error) {
+	cur := make([]uint64, len(src))
+	copy(cur, src)
+	return builder.build(cur)
+}
+
+// build builds Wavelet-Matrix from cur. The content of cur is destroyed.
+func (builder *wmBuilderData) build(cur []uint64) (WaveletMatrix, error) {
 	builder.wm = &WMData{}
 	wm := builder.wm
-	alphabetNum := getAlphabetNum(src)
-	wm.alphabetNum = alphabetNum
-	if alphabetNum == 0 {
+	if len(cur) == 0 {
 		return nil, ErrorEmpty
 	}
+	alphabetNum, err := getAlphabetNum(cur)
+	if err != nil {
+		return nil, err
+	}
+	if builder.alphabetNum != 0 {
+		if alphabetNum > builder.alphabetNum {
+			return nil, ErrorOutOfAlphabet
+		}
+		alphabetNum = builder.alphabetNum
+	}
+	wm.alphabetNum = alphabetNum
 
-	alphabetBitNum := log2(alphabetNum)
+	alphabetBitNum := levelNumOf(alphabetNum)
 	wm.alphabetBitNum = alphabetBitNum
 
-	wm.size = uint64(len(src))
-	wm.nodePos = make([][]uint64, alphabetBitNum)
+	wm.size = uint64(len(cur))
+	wm.zeros = make([]uint64, alphabetBitNum)
+	wm.bv = make([]bitVector, alphabetBitNum)
+	wm.backend = builder.backend
 
-	bvBuilders := make([]sbvector.SuccinctBitVectorBuilder, alphabetBitNum)
-	for i := uint64(0); i < alphabetBitNum; i++ {
-		bvBuilders[i] = sbvector.NewVectorBuilder()
+	if builder.weights != nil {
+		if uint64(len(builder.weights)) != wm.size {
+			return nil, ErrorWeightsLength
+		}
+		if err := builder.buildWeightedLevels(cur); err != nil {
+			return nil, err
+		}
+		return wm, nil
+	}
+	if builder.workers > 1 {
+		if err := builder.buildLevelsParallel(cur); err != nil {
+			return nil, err
+		}
+		return wm, nil
 	}
-	wm.bv = make([]*sbvector.BitVectorData, alphabetBitNum)
 
-	dummy := make([]uint64, 2)
-	dummy[0] = uint64(0)
-	dummy[1] = uint64(wm.size)
-	prev_begin_pos := &dummy
+	next := make([]uint64, wm.size)
 	for i := uint64(0); i < alphabetBitNum; i++ {
-		wm.nodePos[i] = make([]uint64, (1 << (i + 1)))
+		shift := alphabetBitNum - i - 1
+		zeros := uint64(0)
 		for j := uint64(0); j < wm.size; j++ {
-			bit := (src[j] >> (alphabetBitNum - i - 1)) & 1
-			subscript := src[j] >> (alphabetBitNum - i)
-			bvElm := true
-			if bit == 0 {
-				bvElm = false
+			if (cur[j]>>shift)&1 == 0 {
+				zeros++
 			}
-			bvBuilders[i].Set((*prev_begin_pos)[subscript], bvElm)
-			(*prev_begin_pos)[subscript]++
-			wm.nodePos[i][(subscript<<1)|bit]++
 		}
+		wm.zeros[i] = zeros
 
-		cur_max := uint64(1) << i
-		rev := cur_max - uint64(1)
-		prev_rev := rev
-
-		for j := rev; j > 0; j-- {
-			rev ^= cur_max - (cur_max / 2 / (j & -j))
-			(*prev_begin_pos)[prev_rev] = (*prev_begin_pos)[rev]
-			prev_rev = rev
+		// Stable partition by the bit: 0s go to the front, 1s go to the back.
+		bvBuilder := builder.backend.NewBuilder(builder.selectIndex)
+		zeroPos := uint64(0)
+		onePos := zeros
+		for j := uint64(0); j < wm.size; j++ {
+			bit := (cur[j] >> shift) & 1
+			bvBuilder.Set(j, toBool(bit))
+			if bit == 0 {
+				next[zeroPos] = cur[j]
+				zeroPos++
+			} else {
+				next[onePos] = cur[j]
+				onePos++
+			}
 		}
-		(*prev_begin_pos)[0] = 0
-
-		cur_max <<= 1
-		rev = uint64(0)
-		sum := uint64(0)
-
-		for j := uint64(0); j < cur_max; rev ^= cur_max - (cur_max / 2 / (j & -j)) {
-			t := wm.nodePos[i][rev]
-			wm.nodePos[i][rev] = sum
-			sum += t
-			j++
+		bv, err := bvBuilder.Build()
+		if err != nil {
+			return nil, err
 		}
-		bv, _ := bvBuilders[i].Build(true, true)
-		wm.bv[i] = bv.(*sbvector.BitVectorData)
-		prev_begin_pos = &((*wm).nodePos[i])
+		wm.bv[i] = bv
+		cur, next = next, cur
 	}
 	return wm, nil
 }
 
-func getAlphabetNum(array []uint64) uint64 {
+func getAlphabetNum(array []uint64) (uint64, error) {
 	alphabetNum := uint64(0)
 	for i := 0; i < len(array); i++ {
+		if array[i] == NotFound {
+			return 0, ErrorReservedValue
+		}
 		if array[i] >= alphabetNum {
 			alphabetNum = array[i] + uint64(1)
 		}
 	}
-	return alphabetNum
+	return alphabetNum, nil
+}
+
+// levelNumOf returns the number of levels for the alphabet size.
+// At least one level is required even if the array consists of only 0.
+func levelNumOf(alphabetNum uint64) uint64 {
+	if alphabetBitNum := log2(alphabetNum); alphabetBitNum > 0 {
+		return alphabetBitNum
+	}
+	return 1
 }
 
 func log2(x uint64) uint64 {
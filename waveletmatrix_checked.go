@@ -0,0 +1,373 @@
+package waveletmatrix
+
+import (
+	"errors"
+
+	"github.com/hideo55/go-pq"
+)
+
+// CheckedWaveletMatrix is interface of Wavelet-Matrix whose API reports invalid arguments by errors.
+//
+// The arguments are checked in the same manner in every method:
+//   - A position pos must satisfy pos < Size() for Lookup, and pos <= Size() otherwise.
+//     A range of positions [begPos, endPos) must satisfy begPos <= endPos <= Size(). Otherwise ErrOutOfRange is returned.
+//   - A character `c` must be in the alphabet of Wavelet-Matrix. Otherwise ErrInvalidSymbol is returned.
+//   - A range of characters [minC, maxC) must satisfy minC <= maxC. Otherwise ErrInvalidRange is returned.
+//   - A rank of Select must be greater than 0(ErrInvalidRank), and must not exceed the number of occurrences(ErrRankTooLarge).
+//     k of QuantileRange must be less than endPos - begPos(ErrRankTooLarge).
+//   - NextValue and PrevValue return ErrNotFound if there is no such value in the range.
+//
+// An empty range is valid. For example, Rank(c, 0) returns 0 and FreqRange on an empty range returns 0.
+// If an error is returned, the other results are NotFound(or nil for lists).
+type CheckedWaveletMatrix interface {
+	Size() uint64
+	Lookup(pos uint64) (uint64, error)
+	Rank(c, pos uint64) (uint64, error)
+	RankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64, err error)
+	RankLessThan(c, pos uint64) (uint64, error)
+	RankMoreThan(c, pos uint64) (uint64, error)
+	Select(c, rank uint64) (uint64, error)
+	SelectFromPos(c, pos, rank uint64) (uint64, error)
+	Freq(c uint64) (uint64, error)
+	FreqSum(minC, maxC uint64) (uint64, error)
+	FreqRange(minC, maxC, begPos, endPos uint64) (uint64, error)
+	QuantileRange(begPos, endPos, k uint64) (pos, val uint64, err error)
+	MaxRange(begPos, endPos uint64) (pos, val uint64, err error)
+	MinRange(begPos, endPos uint64) (pos, val uint64, err error)
+	NextValue(begPos, endPos, x uint64) (pos, val uint64, err error)
+	PrevValue(begPos, endPos, x uint64) (pos, val uint64, err error)
+	ListModeRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error)
+	ListMinRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error)
+	ListMaxRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error)
+}
+
+var (
+	// ErrOutOfRange indicates that the position or the range of positions is out of the array.
+	ErrOutOfRange = errors.New("waveletmatrix: position is out of range")
+	// ErrInvalidSymbol indicates that the character is not in the alphabet.
+	ErrInvalidSymbol = errors.New("waveletmatrix: invalid symbol")
+	// ErrInvalidRange indicates that the range of characters is invalid(minC > maxC).
+	ErrInvalidRange = errors.New("waveletmatrix: invalid range of characters")
+	// ErrInvalidRank indicates that the rank is 0.
+	ErrInvalidRank = errors.New("waveletmatrix: rank must be greater than 0")
+	// ErrRankTooLarge indicates that the rank exceeds the number of elements.
+	ErrRankTooLarge = errors.New("waveletmatrix: rank is too large")
+	// ErrNotFound indicates that no value satisfies the condition.
+	ErrNotFound = errors.New("waveletmatrix: value is not found")
+)
+
+// legacyMatrix adapts CheckedWaveletMatrix to the methods of WaveletMatrix, which report invalid arguments by
+// false, NotFound or 0 instead of errors. Every kind of Wavelet-Matrix routes those methods through it.
+type legacyMatrix struct {
+	checked CheckedWaveletMatrix
+}
+
+func (l legacyMatrix) Lookup(pos uint64) (uint64, bool) {
+	c, err := l.checked.Lookup(pos)
+	return c, err == nil
+}
+
+func (l legacyMatrix) Rank(c, pos uint64) (uint64, bool) {
+	rank, err := l.checked.Rank(c, pos)
+	return rank, err == nil
+}
+
+func (l legacyMatrix) RankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
+	rank, rankLessThan, rankMoreThan, _ = l.checked.RankAll(c, beginPos, endPos)
+	return
+}
+
+func (l legacyMatrix) RankLessThan(c, pos uint64) uint64 {
+	rank, _ := l.checked.RankLessThan(c, pos)
+	return rank
+}
+
+func (l legacyMatrix) RankMoreThan(c, pos uint64) uint64 {
+	rank, _ := l.checked.RankMoreThan(c, pos)
+	return rank
+}
+
+func (l legacyMatrix) Select(c, rank uint64) (uint64, bool) {
+	pos, err := l.checked.Select(c, rank)
+	return pos, err == nil
+}
+
+func (l legacyMatrix) SelectFromPos(c, pos, rank uint64) (uint64, bool) {
+	pos, err := l.checked.SelectFromPos(c, pos, rank)
+	return pos, err == nil
+}
+
+func (l legacyMatrix) Freq(c uint64) uint64 {
+	freq, err := l.checked.Freq(c)
+	if err != nil {
+		return 0
+	}
+	return freq
+}
+
+func (l legacyMatrix) FreqSum(minC, maxC uint64) uint64 {
+	freq, err := l.checked.FreqSum(minC, maxC)
+	if err != nil {
+		return 0
+	}
+	return freq
+}
+
+func (l legacyMatrix) FreqRange(minC, maxC, begPos, endPos uint64) uint64 {
+	freq, err := l.checked.FreqRange(minC, maxC, begPos, endPos)
+	if err != nil {
+		return 0
+	}
+	return freq
+}
+
+func (l legacyMatrix) QuantileRange(begPos, endPos, k uint64) (pos, val uint64) {
+	pos, val, _ = l.checked.QuantileRange(begPos, endPos, k)
+	return
+}
+
+func (l legacyMatrix) MaxRange(begPos, endPos uint64) (pos, val uint64) {
+	pos, val, _ = l.checked.MaxRange(begPos, endPos)
+	return
+}
+
+func (l legacyMatrix) MinRange(begPos, endPos uint64) (pos, val uint64) {
+	pos, val, _ = l.checked.MinRange(begPos, endPos)
+	return
+}
+
+func (l legacyMatrix) NextValue(begPos, endPos, x uint64) (pos, val uint64) {
+	pos, val, _ = l.checked.NextValue(begPos, endPos, x)
+	return
+}
+
+func (l legacyMatrix) PrevValue(begPos, endPos, x uint64) (pos, val uint64) {
+	pos, val, _ = l.checked.PrevValue(begPos, endPos, x)
+	return
+}
+
+func (l legacyMatrix) ListModeRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	res, _ := l.checked.ListModeRange(minC, maxC, begPos, endPos, num)
+	return res
+}
+
+func (l legacyMatrix) ListMinRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	res, _ := l.checked.ListMinRange(minC, maxC, begPos, endPos, num)
+	return res
+}
+
+func (l legacyMatrix) ListMaxRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	res, _ := l.checked.ListMaxRange(minC, maxC, begPos, endPos, num)
+	return res
+}
+
+// matrixQueries is the set of unchecked queries of a Wavelet-Matrix, whose arguments checkedMatrix validates.
+type matrixQueries interface {
+	Size() uint64
+	alphabetSize() uint64
+	lookup(pos uint64) uint64
+	rank(c, beginPos, endPos uint64) uint64
+	rankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64)
+	rankLessThan(c, begPos, endPos uint64) uint64
+	selectFromPos(c, pos, rank uint64) (uint64, bool)
+	quantileRange(begPos, endPos, k uint64) (pos, val uint64)
+	listRange(minC, maxC, begPos, endPos, num uint64, comparator pq.CmpFunc) []ListResult
+}
+
+// checkedMatrix implements CheckedWaveletMatrix on top of the unchecked queries of a Wavelet-Matrix.
+type checkedMatrix struct {
+	wm matrixQueries
+}
+
+// Size returns size of wavelet-matrix
+func (cwm checkedMatrix) Size() uint64 {
+	return cwm.wm.Size()
+}
+
+// Lookup returns value of pos-th element of wavelet-matrix.
+func (cwm checkedMatrix) Lookup(pos uint64) (uint64, error) {
+	if pos >= cwm.wm.Size() {
+		return NotFound, ErrOutOfRange
+	}
+	return cwm.wm.lookup(pos), nil
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (cwm checkedMatrix) Rank(c, pos uint64) (uint64, error) {
+	if c >= cwm.wm.alphabetSize() {
+		return NotFound, ErrInvalidSymbol
+	}
+	if pos > cwm.wm.Size() {
+		return NotFound, ErrOutOfRange
+	}
+	return cwm.wm.rank(c, 0, pos), nil
+}
+
+// RankAll returns the frequency of characters c' < c, c'=c, and c' > c, in the subarray A[begPos...endPos)
+func (cwm checkedMatrix) RankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64, err error) {
+	if c >= cwm.wm.alphabetSize() {
+		err = ErrInvalidSymbol
+	} else {
+		err = checkPosRange(beginPos, endPos, cwm.wm.Size())
+	}
+	if err != nil {
+		return NotFound, NotFound, NotFound, err
+	}
+	rank, rankLessThan, rankMoreThan = cwm.wm.rankAll(c, beginPos, endPos)
+	return
+}
+
+// RankLessThan returns the frequency of characters c' < c in the subarray A[0...pos)
+func (cwm checkedMatrix) RankLessThan(c, pos uint64) (uint64, error) {
+	_, rank, _, err := cwm.RankAll(c, 0, pos)
+	return rank, err
+}
+
+// RankMoreThan returns the frequency of characters c' > c in the subarray A[0...pos)
+func (cwm checkedMatrix) RankMoreThan(c, pos uint64) (uint64, error) {
+	_, _, rank, err := cwm.RankAll(c, 0, pos)
+	return rank, err
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (cwm checkedMatrix) Select(c, rank uint64) (uint64, error) {
+	return cwm.SelectFromPos(c, 0, rank)
+}
+
+// SelectFromPos returns the position of the rank-th occurrence of `c` in the suffix of the array starting from 'pos'
+func (cwm checkedMatrix) SelectFromPos(c, pos, rank uint64) (uint64, error) {
+	if c >= cwm.wm.alphabetSize() {
+		return NotFound, ErrInvalidSymbol
+	}
+	if pos > cwm.wm.Size() {
+		return NotFound, ErrOutOfRange
+	}
+	if rank == 0 {
+		return NotFound, ErrInvalidRank
+	}
+	res, found := cwm.wm.selectFromPos(c, pos, rank)
+	if !found {
+		return NotFound, ErrRankTooLarge
+	}
+	return res, nil
+}
+
+// Freq returns the frequency of the character `c`.
+func (cwm checkedMatrix) Freq(c uint64) (uint64, error) {
+	return cwm.Rank(c, cwm.wm.Size())
+}
+
+// FreqSum returns frequency of the characters(minC <= c' < maxC)
+func (cwm checkedMatrix) FreqSum(minC, maxC uint64) (uint64, error) {
+	return cwm.FreqRange(minC, maxC, 0, cwm.wm.Size())
+}
+
+// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
+func (cwm checkedMatrix) FreqRange(minC, maxC, begPos, endPos uint64) (uint64, error) {
+	if err := checkCharRange(minC, maxC); err != nil {
+		return NotFound, err
+	}
+	if err := checkPosRange(begPos, endPos, cwm.wm.Size()); err != nil {
+		return NotFound, err
+	}
+	wm := cwm.wm
+	return wm.rankLessThan(maxC, begPos, endPos) - wm.rankLessThan(minC, begPos, endPos), nil
+}
+
+// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
+func (cwm checkedMatrix) QuantileRange(begPos, endPos, k uint64) (pos, val uint64, err error) {
+	if err = checkPosRange(begPos, endPos, cwm.wm.Size()); err != nil {
+		return NotFound, NotFound, err
+	}
+	if k >= endPos-begPos {
+		return NotFound, NotFound, ErrRankTooLarge
+	}
+	pos, val = cwm.wm.quantileRange(begPos, endPos, k)
+	return
+}
+
+// MaxRange returns maximum value(and position) in the subarray A[begPos .. endPos)
+func (cwm checkedMatrix) MaxRange(begPos, endPos uint64) (pos, val uint64, err error) {
+	if err = checkPosRange(begPos, endPos, cwm.wm.Size()); err != nil {
+		return NotFound, NotFound, err
+	}
+	return cwm.QuantileRange(begPos, endPos, endPos-begPos-uint64(1))
+}
+
+// MinRange returns minimum value(and position) in the subarray A[begPos .. endPos)
+func (cwm checkedMatrix) MinRange(begPos, endPos uint64) (pos, val uint64, err error) {
+	return cwm.QuantileRange(begPos, endPos, 0)
+}
+
+// NextValue returns the smallest value c >= x(and the position of its first occurrence) in the subarray A[begPos ... endPos)
+func (cwm checkedMatrix) NextValue(begPos, endPos, x uint64) (pos, val uint64, err error) {
+	if err = checkPosRange(begPos, endPos, cwm.wm.Size()); err != nil {
+		return NotFound, NotFound, err
+	}
+	wm := cwm.wm
+	k := wm.rankLessThan(x, begPos, endPos)
+	if k == endPos-begPos {
+		return NotFound, NotFound, ErrNotFound
+	}
+	pos, val = wm.quantileRange(begPos, endPos, k)
+	return
+}
+
+// PrevValue returns the largest value c < x(and the position of its last occurrence) in the subarray A[begPos ... endPos)
+func (cwm checkedMatrix) PrevValue(begPos, endPos, x uint64) (pos, val uint64, err error) {
+	if err = checkPosRange(begPos, endPos, cwm.wm.Size()); err != nil {
+		return NotFound, NotFound, err
+	}
+	wm := cwm.wm
+	k := wm.rankLessThan(x, begPos, endPos)
+	if k == 0 {
+		return NotFound, NotFound, ErrNotFound
+	}
+	pos, val = wm.quantileRange(begPos, endPos, k-uint64(1))
+	return
+}
+
+// ListModeRange returns list of the distinct characters appeared in A[begPos ... endPos) from most frequent ones.
+func (cwm checkedMatrix) ListModeRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error) {
+	if err := checkListRange(minC, maxC, begPos, endPos, cwm.wm.Size()); err != nil {
+		return nil, err
+	}
+	return cwm.wm.listRange(minC, maxC, begPos, endPos, num, modeComparator), nil
+}
+
+// ListMinRange returns list of the distinct characters in A[begPos ... endPos) minC <= c < maxC  from smallest ones.
+func (cwm checkedMatrix) ListMinRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error) {
+	if err := checkListRange(minC, maxC, begPos, endPos, cwm.wm.Size()); err != nil {
+		return nil, err
+	}
+	return cwm.wm.listRange(minC, maxC, begPos, endPos, num, minComparator), nil
+}
+
+// ListMaxRange returns list of the distinct characters appeared in A[begPos ... endPos) from largest ones.
+func (cwm checkedMatrix) ListMaxRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error) {
+	if err := checkListRange(minC, maxC, begPos, endPos, cwm.wm.Size()); err != nil {
+		return nil, err
+	}
+	return cwm.wm.listRange(minC, maxC, begPos, endPos, num, maxComparator), nil
+}
+
+func checkPosRange(begPos, endPos, size uint64) error {
+	if begPos > endPos || endPos > size {
+		return ErrOutOfRange
+	}
+	return nil
+}
+
+func checkCharRange(minC, maxC uint64) error {
+	if minC > maxC {
+		return ErrInvalidRange
+	}
+	return nil
+}
+
+func checkListRange(minC, maxC, begPos, endPos, size uint64) error {
+	if err := checkCharRange(minC, maxC); err != nil {
+		return err
+	}
+	return checkPosRange(begPos, endPos, size)
+}
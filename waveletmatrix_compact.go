@@ -0,0 +1,361 @@
+package waveletmatrix
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/hideo55/go-pq"
+)
+
+// CompactWMData holds Wavelet-Matrix that is built over the ranks of the distinct values.
+// It is suitable for sparse alphabets(hashes, timestamps, IDs, ...).
+// Arguments and results of each API are translated from/to the original values transparently.
+type CompactWMData struct {
+	wm     *WMData
+	values []uint64
+}
+
+// NewCompactWM builds Wavelet-Matrix that maps the distinct values of src to a dense rank space.
+func NewCompactWM(src []uint64) (WaveletMatrix, error) {
+	if len(src) == 0 {
+		return nil, ErrorEmpty
+	}
+	values := make([]uint64, len(src))
+	copy(values, src)
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	n := 0
+	for i := 0; i < len(values); i++ {
+		if i == 0 || values[i] != values[n-1] {
+			values[n] = values[i]
+			n++
+		}
+	}
+	values = values[:n:n]
+
+	cwm := &CompactWMData{values: values}
+	ranks := make([]uint64, len(src))
+	for i := 0; i < len(src); i++ {
+		ranks[i], _ = cwm.toRank(src[i])
+	}
+	builder := newWMBuilder(nil)
+	wm, err := builder.build(ranks)
+	if err != nil {
+		return nil, err
+	}
+	cwm.wm = wm.(*WMData)
+	return cwm, nil
+}
+
+// NewCompactWMFromBinary restores CompactWMData from the binary created by MarshalBinary.
+func NewCompactWMFromBinary(data []byte) (WaveletMatrix, error) {
+	cwm := new(CompactWMData)
+	err := cwm.UnmarshalBinary(data)
+	return cwm, err
+}
+
+// toRank returns the number of distinct values less than c, and whether c appears in the array.
+func (cwm *CompactWMData) toRank(c uint64) (uint64, bool) {
+	i := sort.Search(len(cwm.values), func(i int) bool { return cwm.values[i] >= c })
+	return uint64(i), i < len(cwm.values) && cwm.values[i] == c
+}
+
+// Size returns size of wavelet-matrix
+func (cwm *CompactWMData) Size() uint64 {
+	return cwm.wm.size
+}
+
+// Checked returns the view of cwm whose API reports invalid arguments by errors.
+// Any value is a valid character. The values which do not appear in the array are treated as characters whose frequency is 0.
+func (cwm *CompactWMData) Checked() CheckedWaveletMatrix {
+	return (*checkedCompactWMData)(cwm)
+}
+
+// Lookup element by pos.
+// This function returns value of pos-th element of wavelet-matrix.
+// if pos >= (size of wavelet-matrix),  value of second result parameter is false.
+func (cwm *CompactWMData) Lookup(pos uint64) (uint64, bool) {
+	return legacyMatrix{cwm.Checked()}.Lookup(pos)
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (cwm *CompactWMData) Rank(c, pos uint64) (uint64, bool) {
+	return legacyMatrix{cwm.Checked()}.Rank(c, pos)
+}
+
+// RankAll returns the frequency of characters c' < c, c'=c, and c' > c, in the subarray A[begPos...endPos)
+func (cwm *CompactWMData) RankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
+	return legacyMatrix{cwm.Checked()}.RankAll(c, beginPos, endPos)
+}
+
+// RankLessThan returns the frequency of characters c' < c in the subarray A[0...pos)
+func (cwm *CompactWMData) RankLessThan(c, pos uint64) uint64 {
+	return legacyMatrix{cwm.Checked()}.RankLessThan(c, pos)
+}
+
+// RankMoreThan returns the frequency of characters c' > c in the subarray A[0...pos)
+func (cwm *CompactWMData) RankMoreThan(c, pos uint64) uint64 {
+	return legacyMatrix{cwm.Checked()}.RankMoreThan(c, pos)
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (cwm *CompactWMData) Select(c, rank uint64) (uint64, bool) {
+	return legacyMatrix{cwm.Checked()}.Select(c, rank)
+}
+
+// SelectFromPos returns the position of the rank-th occurrence of `c` in the suffix of the array starting from 'pos'
+func (cwm *CompactWMData) SelectFromPos(c, pos, rank uint64) (uint64, bool) {
+	return legacyMatrix{cwm.Checked()}.SelectFromPos(c, pos, rank)
+}
+
+// Freq returns the frequency of the character `c`.
+func (cwm *CompactWMData) Freq(c uint64) uint64 {
+	return legacyMatrix{cwm.Checked()}.Freq(c)
+}
+
+// FreqSum returns frequency of the characters(minC <= c' < maxC)
+func (cwm *CompactWMData) FreqSum(minC, maxC uint64) uint64 {
+	return legacyMatrix{cwm.Checked()}.FreqSum(minC, maxC)
+}
+
+// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
+func (cwm *CompactWMData) FreqRange(minC, maxC, begPos, endPos uint64) uint64 {
+	return legacyMatrix{cwm.Checked()}.FreqRange(minC, maxC, begPos, endPos)
+}
+
+// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
+func (cwm *CompactWMData) QuantileRange(begPos, endPos, k uint64) (pos, val uint64) {
+	return legacyMatrix{cwm.Checked()}.QuantileRange(begPos, endPos, k)
+}
+
+// MaxRange returns maximum value(and position) in the subarray A[begPos .. endPos]
+func (cwm *CompactWMData) MaxRange(begPos, endPos uint64) (pos, val uint64) {
+	return legacyMatrix{cwm.Checked()}.MaxRange(begPos, endPos)
+}
+
+// MinRange returns minimum value(and position) in the subarray A[begPos .. endPos]
+func (cwm *CompactWMData) MinRange(begPos, endPos uint64) (pos, val uint64) {
+	return legacyMatrix{cwm.Checked()}.MinRange(begPos, endPos)
+}
+
+// NextValue returns the smallest value c >= x(and the position of its first occurrence) in the subarray A[begPos ... endPos)
+func (cwm *CompactWMData) NextValue(begPos, endPos, x uint64) (pos, val uint64) {
+	return legacyMatrix{cwm.Checked()}.NextValue(begPos, endPos, x)
+}
+
+// PrevValue returns the largest value c < x(and the position of its last occurrence) in the subarray A[begPos ... endPos)
+func (cwm *CompactWMData) PrevValue(begPos, endPos, x uint64) (pos, val uint64) {
+	return legacyMatrix{cwm.Checked()}.PrevValue(begPos, endPos, x)
+}
+
+// ListModeRange returns list of the distinct characters appeared in A[begPos ... endPos) from most frequent ones.
+func (cwm *CompactWMData) ListModeRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{cwm.Checked()}.ListModeRange(minC, maxC, begPos, endPos, num)
+}
+
+// ListMinRange returns list of the distinct characters in A[begPos ... endPos) minC <= c < maxC  from smallest ones.
+func (cwm *CompactWMData) ListMinRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{cwm.Checked()}.ListMinRange(minC, maxC, begPos, endPos, num)
+}
+
+// ListMaxRange returns list of the distinct characters appeared in A[begPos ... endPos) from largest ones.
+func (cwm *CompactWMData) ListMaxRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{cwm.Checked()}.ListMaxRange(minC, maxC, begPos, endPos, num)
+}
+
+type checkedCompactWMData CompactWMData
+
+// Size returns size of wavelet-matrix
+func (ccwm *checkedCompactWMData) Size() uint64 {
+	return ccwm.wm.size
+}
+
+// Lookup returns value of pos-th element of wavelet-matrix.
+func (ccwm *checkedCompactWMData) Lookup(pos uint64) (uint64, error) {
+	r, err := ccwm.wm.Checked().Lookup(pos)
+	if err != nil {
+		return NotFound, err
+	}
+	return ccwm.values[r], nil
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (ccwm *checkedCompactWMData) Rank(c, pos uint64) (uint64, error) {
+	if pos > ccwm.wm.size {
+		return NotFound, ErrOutOfRange
+	}
+	r, found := (*CompactWMData)(ccwm).toRank(c)
+	if !found {
+		return 0, nil
+	}
+	return ccwm.wm.rank(r, 0, pos), nil
+}
+
+// RankAll returns the frequency of characters c' < c, c'=c, and c' > c, in the subarray A[begPos...endPos)
+func (ccwm *checkedCompactWMData) RankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64, err error) {
+	if err = checkPosRange(beginPos, endPos, ccwm.wm.size); err != nil {
+		return NotFound, NotFound, NotFound, err
+	}
+	r, found := (*CompactWMData)(ccwm).toRank(c)
+	if found {
+		rank, rankLessThan, rankMoreThan = ccwm.wm.rankAll(r, beginPos, endPos)
+		return
+	}
+	rankLessThan = ccwm.wm.rankLessThan(r, beginPos, endPos)
+	rankMoreThan = endPos - beginPos - rankLessThan
+	return
+}
+
+// RankLessThan returns the frequency of characters c' < c in the subarray A[0...pos)
+func (ccwm *checkedCompactWMData) RankLessThan(c, pos uint64) (uint64, error) {
+	_, rank, _, err := ccwm.RankAll(c, 0, pos)
+	return rank, err
+}
+
+// RankMoreThan returns the frequency of characters c' > c in the subarray A[0...pos)
+func (ccwm *checkedCompactWMData) RankMoreThan(c, pos uint64) (uint64, error) {
+	_, _, rank, err := ccwm.RankAll(c, 0, pos)
+	return rank, err
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (ccwm *checkedCompactWMData) Select(c, rank uint64) (uint64, error) {
+	return ccwm.SelectFromPos(c, 0, rank)
+}
+
+// SelectFromPos returns the position of the rank-th occurrence of `c` in the suffix of the array starting from 'pos'
+func (ccwm *checkedCompactWMData) SelectFromPos(c, pos, rank uint64) (uint64, error) {
+	if pos > ccwm.wm.size {
+		return NotFound, ErrOutOfRange
+	}
+	if rank == 0 {
+		return NotFound, ErrInvalidRank
+	}
+	r, found := (*CompactWMData)(ccwm).toRank(c)
+	if !found {
+		return NotFound, ErrRankTooLarge
+	}
+	res, found := ccwm.wm.selectFromPos(r, pos, rank)
+	if !found {
+		return NotFound, ErrRankTooLarge
+	}
+	return res, nil
+}
+
+// Freq returns the frequency of the character `c`.
+func (ccwm *checkedCompactWMData) Freq(c uint64) (uint64, error) {
+	return ccwm.Rank(c, ccwm.wm.size)
+}
+
+// FreqSum returns frequency of the characters(minC <= c' < maxC)
+func (ccwm *checkedCompactWMData) FreqSum(minC, maxC uint64) (uint64, error) {
+	return ccwm.FreqRange(minC, maxC, 0, ccwm.wm.size)
+}
+
+// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
+func (ccwm *checkedCompactWMData) FreqRange(minC, maxC, begPos, endPos uint64) (uint64, error) {
+	if err := checkListRange(minC, maxC, begPos, endPos, ccwm.wm.size); err != nil {
+		return NotFound, err
+	}
+	minR, maxR := (*CompactWMData)(ccwm).toRankRange(minC, maxC)
+	return ccwm.wm.rankLessThan(maxR, begPos, endPos) - ccwm.wm.rankLessThan(minR, begPos, endPos), nil
+}
+
+// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
+func (ccwm *checkedCompactWMData) QuantileRange(begPos, endPos, k uint64) (pos, val uint64, err error) {
+	pos, val, err = ccwm.wm.Checked().QuantileRange(begPos, endPos, k)
+	if err == nil {
+		val = ccwm.values[val]
+	}
+	return
+}
+
+// MaxRange returns maximum value(and position) in the subarray A[begPos .. endPos)
+func (ccwm *checkedCompactWMData) MaxRange(begPos, endPos uint64) (pos, val uint64, err error) {
+	pos, val, err = ccwm.wm.Checked().MaxRange(begPos, endPos)
+	if err == nil {
+		val = ccwm.values[val]
+	}
+	return
+}
+
+// MinRange returns minimum value(and position) in the subarray A[begPos .. endPos)
+func (ccwm *checkedCompactWMData) MinRange(begPos, endPos uint64) (pos, val uint64, err error) {
+	return ccwm.QuantileRange(begPos, endPos, 0)
+}
+
+// NextValue returns the smallest value c >= x(and the position of its first occurrence) in the subarray A[begPos ... endPos)
+func (ccwm *checkedCompactWMData) NextValue(begPos, endPos, x uint64) (pos, val uint64, err error) {
+	r, _ := (*CompactWMData)(ccwm).toRank(x)
+	pos, val, err = ccwm.wm.Checked().NextValue(begPos, endPos, r)
+	if err == nil {
+		val = ccwm.values[val]
+	}
+	return
+}
+
+// PrevValue returns the largest value c < x(and the position of its last occurrence) in the subarray A[begPos ... endPos)
+func (ccwm *checkedCompactWMData) PrevValue(begPos, endPos, x uint64) (pos, val uint64, err error) {
+	r, _ := (*CompactWMData)(ccwm).toRank(x)
+	pos, val, err = ccwm.wm.Checked().PrevValue(begPos, endPos, r)
+	if err == nil {
+		val = ccwm.values[val]
+	}
+	return
+}
+
+func (ccwm *checkedCompactWMData) listRange(minC, maxC, begPos, endPos, num uint64, comparator pq.CmpFunc) ([]ListResult, error) {
+	if err := checkListRange(minC, maxC, begPos, endPos, ccwm.wm.size); err != nil {
+		return nil, err
+	}
+	cwm := (*CompactWMData)(ccwm)
+	minR, maxR := cwm.toRankRange(minC, maxC)
+	return cwm.toValues(ccwm.wm.listRange(minR, maxR, begPos, endPos, num, comparator)), nil
+}
+
+// ListModeRange returns list of the distinct characters appeared in A[begPos ... endPos) from most frequent ones.
+func (ccwm *checkedCompactWMData) ListModeRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error) {
+	return ccwm.listRange(minC, maxC, begPos, endPos, num, modeComparator)
+}
+
+// ListMinRange returns list of the distinct characters in A[begPos ... endPos) minC <= c < maxC  from smallest ones.
+func (ccwm *checkedCompactWMData) ListMinRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error) {
+	return ccwm.listRange(minC, maxC, begPos, endPos, num, minComparator)
+}
+
+// ListMaxRange returns list of the distinct characters appeared in A[begPos ... endPos) from largest ones.
+func (ccwm *checkedCompactWMData) ListMaxRange(minC, maxC, begPos, endPos, num uint64) ([]ListResult, error) {
+	return ccwm.listRange(minC, maxC, begPos, endPos, num, maxComparator)
+}
+
+// toRankRange maps the range of characters [minC, maxC) to the range of ranks.
+func (cwm *CompactWMData) toRankRange(minC, maxC uint64) (minR, maxR uint64) {
+	minR, _ = cwm.toRank(minC)
+	maxR, _ = cwm.toRank(maxC)
+	return
+}
+
+func (cwm *CompactWMData) toValues(res []ListResult) []ListResult {
+	for i := 0; i < len(res); i++ {
+		res[i].C = cwm.values[res[i].C]
+	}
+	return res
+}
+
+/*
+MarshalBinary implements the encoding.BinaryMarshaler interface.
+*/
+func (cwm *CompactWMData) MarshalBinary() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	if _, err := cwm.WriteTo(buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+/*
+UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+*/
+func (cwm *CompactWMData) UnmarshalBinary(data []byte) error {
+	_, err := cwm.ReadFrom(bytes.NewReader(data))
+	return err
+}
@@ -0,0 +1,146 @@
+package waveletmatrix
+
+import (
+	"errors"
+)
+
+// concatRun is a run of consecutive elements of a level which come from the same matrix.
+type concatRun struct {
+	src    int
+	length uint64
+}
+
+var (
+	// ErrorConcatWeights indicates that only one of the matrices is weighted.
+	ErrorConcatWeights = errors.New("Both or neither of matrices must be weighted.")
+)
+
+/*
+Concat returns Wavelet-Matrix of the array of a followed by the array of b, which is equivalent to NewWM(append(srcA, srcB...)).
+The bit vectors of the levels are merged directly without decoding the values.
+If the numbers of levels differ, the matrix of fewer levels is treated as having the levels of 0 bits at the top.
+The result uses the bit vector backend of a. If both are weighted, the weights are also concatenated.
+The matrices other than WMData and DynamicWMData, such as CompactWMData and MultiaryWMData, are decoded by Extract
+and rebuilt by NewWM before merging, since their levels do not hold the bits of the values.
+
+In each level, the elements of the result are runs of consecutive elements of a and b, and the runs keep the order of
+each matrix. The elements of bit 0 of the runs, followed by the elements of bit 1, form the runs of the next level.
+*/
+func Concat(a, b WaveletMatrix) (WaveletMatrix, error) {
+	srcs := [2]*WMData{}
+	for i, wm := range []WaveletMatrix{a, b} {
+		switch wm := wm.(type) {
+		case *WMData:
+			srcs[i] = wm
+		case *DynamicWMData:
+			srcs[i] = &wm.WMData
+		default:
+			built, err := NewWM(wm.Extract(0, wm.Size()))
+			if err != nil {
+				return nil, err
+			}
+			srcs[i] = built.(*WMData)
+		}
+	}
+	weighted := len(srcs[0].weightSums) > 0
+	if weighted != (len(srcs[1].weightSums) > 0) {
+		return nil, ErrorConcatWeights
+	}
+
+	wm := &WMData{size: srcs[0].size + srcs[1].size, backend: srcs[0].levelBackend()}
+	for _, src := range srcs {
+		if src.alphabetNum > wm.alphabetNum {
+			wm.alphabetNum = src.alphabetNum
+		}
+		if src.alphabetBitNum > wm.alphabetBitNum {
+			wm.alphabetBitNum = src.alphabetBitNum
+		}
+	}
+	// padding[i] is the number of the levels of 0 bits at the top of srcs[i].
+	padding := [2]uint64{wm.alphabetBitNum - srcs[0].alphabetBitNum, wm.alphabetBitNum - srcs[1].alphabetBitNum}
+	wm.bv = make([]bitVector, wm.alphabetBitNum)
+	wm.zeros = make([]uint64, wm.alphabetBitNum)
+	if weighted {
+		wm.weightSums = make([][]uint64, wm.alphabetBitNum+1)
+	}
+
+	runs := appendConcatRun(appendConcatRun(nil, concatRun{0, srcs[0].size}), concatRun{1, srcs[1].size})
+	for i := uint64(0); ; i++ {
+		if weighted {
+			wm.weightSums[i] = concatWeightSums(srcs, padding, i, runs)
+		}
+		if i == wm.alphabetBitNum {
+			break
+		}
+		bvBuilder := wm.backend.NewBuilder(true)
+		var zeroRuns, oneRuns []concatRun
+		pos := uint64(0)
+		offsets := [2]uint64{}
+		for _, run := range runs {
+			src := srcs[run.src]
+			zeros := run.length
+			if i < padding[run.src] {
+				for j := uint64(0); j < run.length; j++ {
+					bvBuilder.Set(pos+j, false)
+				}
+			} else {
+				level := i - padding[run.src]
+				beg := offsets[run.src]
+				for j := uint64(0); j < run.length; j++ {
+					bit, _ := src.bv[level].Get(beg + j)
+					bvBuilder.Set(pos+j, bit)
+				}
+				begZero, _ := src.bv[level].Rank0(beg)
+				endZero, _ := src.bv[level].Rank0(beg + run.length)
+				zeros = endZero - begZero
+			}
+			zeroRuns = appendConcatRun(zeroRuns, concatRun{run.src, zeros})
+			oneRuns = appendConcatRun(oneRuns, concatRun{run.src, run.length - zeros})
+			wm.zeros[i] += zeros
+			offsets[run.src] += run.length
+			pos += run.length
+		}
+		bv, err := bvBuilder.Build()
+		if err != nil {
+			return nil, err
+		}
+		wm.bv[i] = bv
+		runs = zeroRuns
+		for _, run := range oneRuns {
+			runs = appendConcatRun(runs, run)
+		}
+	}
+	return wm, nil
+}
+
+// appendConcatRun appends run to runs, joining it to the last run of the same matrix. Empty runs are dropped.
+func appendConcatRun(runs []concatRun, run concatRun) []concatRun {
+	if run.length == 0 {
+		return runs
+	}
+	if len(runs) > 0 && runs[len(runs)-1].src == run.src {
+		runs[len(runs)-1].length += run.length
+		return runs
+	}
+	return append(runs, run)
+}
+
+// concatWeightSums returns the cumulative weights of the level i of the result, whose elements are the runs.
+func concatWeightSums(srcs [2]*WMData, padding [2]uint64, i uint64, runs []concatRun) []uint64 {
+	sums := make([]uint64, 1, srcs[0].size+srcs[1].size+1)
+	offsets := [2]uint64{}
+	for _, run := range runs {
+		// The order of the padding levels is the order of the array.
+		srcSums := srcs[run.src].weightSums[0]
+		if i > padding[run.src] {
+			srcSums = srcs[run.src].weightSums[i-padding[run.src]]
+		}
+		total := sums[len(sums)-1]
+		beg := offsets[run.src]
+		for j := beg; j < beg+run.length; j++ {
+			sums = append(sums, total+srcSums[j+1]-srcSums[beg])
+		}
+		offsets[run.src] += run.length
+	}
+	return sums
+}
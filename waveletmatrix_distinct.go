@@ -0,0 +1,104 @@
+package waveletmatrix
+
+// DistinctRange returns the number of the distinct characters in the subarray A[begPos ... endPos)
+func (wm *WMData) DistinctRange(begPos, endPos uint64) uint64 {
+	return wm.DistinctValueRange(0, NotFound, begPos, endPos)
+}
+
+// DistinctValueRange returns the number of the distinct characters minC <= c < maxC in the subarray A[begPos ... endPos)
+// The nodes which hold the elements of the range are traversed without listing the characters, so that it takes
+// O(d log σ) time for d distinct characters. If the arguments are invalid, 0 is returned.
+func (wm *WMData) DistinctValueRange(minC, maxC, begPos, endPos uint64) uint64 {
+	if checkListRange(minC, maxC, begPos, endPos, wm.size) != nil {
+		return 0
+	}
+	return wm.distinctRange(minC, maxC, begPos, endPos)
+}
+
+// distinctRange returns the number of the distinct characters minC <= c < maxC in A[begPos ... endPos) without argument checks.
+func (wm *WMData) distinctRange(minC, maxC, begPos, endPos uint64) uint64 {
+	if begPos >= endPos || minC >= maxC {
+		return 0
+	}
+	distinct := uint64(0)
+	stack := []queryOnNode{{begPos, endPos, 0, 0}}
+	for len(stack) > 0 {
+		qon := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if qon.depth >= wm.alphabetBitNum {
+			distinct++
+			continue
+		}
+		bv := wm.bv[qon.depth]
+		begZero, _ := bv.Rank0(qon.begPos)
+		endZero, _ := bv.Rank0(qon.endPos)
+		if endZero > begZero {
+			prefix := qon.prefixChar << 1
+			if wm.checkPrefix(prefix, qon.depth+1, minC, maxC) {
+				stack = append(stack, queryOnNode{begZero, endZero, qon.depth + 1, prefix})
+			}
+		}
+		begOne := qon.begPos - begZero
+		endOne := qon.endPos - endZero
+		if endOne > begOne {
+			prefix := (qon.prefixChar << 1) + uint64(1)
+			if wm.checkPrefix(prefix, qon.depth+1, minC, maxC) {
+				zeros := wm.zeros[qon.depth]
+				stack = append(stack, queryOnNode{zeros + begOne, zeros + endOne, qon.depth + 1, prefix})
+			}
+		}
+	}
+	return distinct
+}
+
+// DistinctRange returns the number of the distinct characters in the subarray A[begPos ... endPos)
+func (cwm *CompactWMData) DistinctRange(begPos, endPos uint64) uint64 {
+	return cwm.wm.DistinctRange(begPos, endPos)
+}
+
+// DistinctValueRange returns the number of the distinct characters minC <= c < maxC in the subarray A[begPos ... endPos)
+// If the arguments are invalid, 0 is returned.
+func (cwm *CompactWMData) DistinctValueRange(minC, maxC, begPos, endPos uint64) uint64 {
+	if checkListRange(minC, maxC, begPos, endPos, cwm.wm.size) != nil {
+		return 0
+	}
+	minR, maxR := cwm.toRankRange(minC, maxC)
+	return cwm.wm.distinctRange(minR, maxR, begPos, endPos)
+}
+
+// DistinctRange returns the number of the distinct characters in the subarray A[begPos ... endPos)
+func (mwm *MultiaryWMData) DistinctRange(begPos, endPos uint64) uint64 {
+	return mwm.DistinctValueRange(0, NotFound, begPos, endPos)
+}
+
+// DistinctValueRange returns the number of the distinct characters minC <= c < maxC in the subarray A[begPos ... endPos)
+// If the arguments are invalid, 0 is returned.
+func (mwm *MultiaryWMData) DistinctValueRange(minC, maxC, begPos, endPos uint64) uint64 {
+	if checkListRange(minC, maxC, begPos, endPos, mwm.size) != nil || begPos == endPos || minC == maxC {
+		return 0
+	}
+	distinct := uint64(0)
+	symbolNum := uint64(1) << mwm.symbolBitNum
+	stack := []queryOnNode{{begPos, endPos, 0, 0}}
+	for len(stack) > 0 {
+		qon := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if qon.depth >= uint64(len(mwm.levels)) {
+			distinct++
+			continue
+		}
+		shift := mwm.shiftOf(qon.depth)
+		for s := uint64(0); s < symbolNum; s++ {
+			prefix := qon.prefixChar<<mwm.symbolBitNum | s
+			if prefix < minC>>shift || prefix > (maxC-uint64(1))>>shift {
+				continue
+			}
+			nextBeg := mwm.nextPos(qon.depth, qon.begPos, s)
+			nextEnd := mwm.nextPos(qon.depth, qon.endPos, s)
+			if nextBeg < nextEnd {
+				stack = append(stack, queryOnNode{nextBeg, nextEnd, qon.depth + 1, prefix})
+			}
+		}
+	}
+	return distinct
+}
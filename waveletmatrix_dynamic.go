@@ -0,0 +1,608 @@
+package waveletmatrix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+// DynamicWaveletMatrix is interface of Wavelet-Matrix which can be updated in place.
+type DynamicWaveletMatrix interface {
+	WaveletMatrix
+	Insert(pos, c uint64) error
+	Delete(pos uint64) error
+	Set(pos, c uint64) error
+}
+
+// DynamicWMData holds Wavelet-Matrix whose levels are dynamic bit vectors.
+// The queries are the same as WMData, and each update takes O(log n) time per level.
+// The number of levels is fixed when it is built, so that the values must be less than 2^(the number of levels).
+// DynamicWMData is not safe for concurrent use if it is updated.
+type DynamicWMData struct {
+	WMData
+}
+
+// dynamicBitVector is the bit vector which supports insertion and deletion of bits.
+// The bits are held in the leaves of AVL tree, and each node holds the number of bits and 1 bits of its subtree.
+type dynamicBitVector struct {
+	root *dynamicNode
+}
+
+type dynamicNode struct {
+	left   *dynamicNode
+	right  *dynamicNode
+	size   uint64
+	ones   uint64
+	height int
+	// words holds the bits of the leaf. It is nil for the internal nodes.
+	words []uint64
+}
+
+type dynamicBackend struct{}
+
+// dynamicBuilder holds the plain bits until Build.
+type dynamicBuilder struct {
+	words []uint64
+	size  uint64
+}
+
+const (
+	// dynamicLeafWords is the number of words of a leaf. A full leaf is split into two halves on insertion.
+	dynamicLeafWords uint64 = 8
+	dynamicLeafBits         = dynamicLeafWords * 64
+)
+
+var dynamicBitVectorBackend bitVectorBackend = dynamicBackend{}
+
+// NewDynamicWM builds Wavelet-Matrix which supports Insert, Delete and Set.
+// src may be empty if the alphabet size is given by WithAlphabetSize. Values up to 2^log2(alphabet size) can be inserted later.
+// WithBitVectorBackend is ignored, since the levels are always the dynamic bit vectors internal to this package.
+func NewDynamicWM(src []uint64, opts ...Option) (DynamicWaveletMatrix, error) {
+	builder := newWMBuilder(opts)
+	builder.backend = dynamicBitVectorBackend
+	if len(src) == 0 && builder.alphabetNum != 0 {
+		dwm := &DynamicWMData{}
+		dwm.alphabetNum = builder.alphabetNum
+		dwm.alphabetBitNum = levelNumOf(builder.alphabetNum)
+		dwm.backend = dynamicBitVectorBackend
+		dwm.zeros = make([]uint64, dwm.alphabetBitNum)
+		dwm.bv = make([]bitVector, dwm.alphabetBitNum)
+		for i := range dwm.bv {
+			dwm.bv[i] = newDynamicBitVector(nil, 0)
+		}
+		return dwm, nil
+	}
+	wm, err := builder.Build(src)
+	if err != nil {
+		return nil, err
+	}
+	return &DynamicWMData{*wm.(*WMData)}, nil
+}
+
+// NewDynamicWMFromBinary restores DynamicWMData from the binary created by MarshalBinary of any Wavelet-Matrix
+// which is not weighted.
+func NewDynamicWMFromBinary(data []byte) (DynamicWaveletMatrix, error) {
+	dwm := new(DynamicWMData)
+	err := dwm.UnmarshalBinary(data)
+	return dwm, err
+}
+
+// Insert inserts `c` before the pos-th element. If pos is equal to the size, `c` is appended.
+func (dwm *DynamicWMData) Insert(pos, c uint64) error {
+	if err := dwm.checkValue(c); err != nil {
+		return err
+	}
+	if pos > dwm.size {
+		return ErrOutOfRange
+	}
+	for i := uint64(0); i < dwm.alphabetBitNum; i++ {
+		b := dwm.bitOf(c, i)
+		dwm.bv[i].(*dynamicBitVector).insert(pos, b)
+		if !b {
+			dwm.zeros[i]++
+		}
+		pos = dwm.nextPos(i, pos, b)
+	}
+	dwm.size++
+	if c >= dwm.alphabetNum {
+		dwm.alphabetNum = c + 1
+	}
+	return nil
+}
+
+// Delete removes the pos-th element. The alphabet size is not changed.
+func (dwm *DynamicWMData) Delete(pos uint64) error {
+	if pos >= dwm.size {
+		return ErrOutOfRange
+	}
+	for i := uint64(0); i < dwm.alphabetBitNum; i++ {
+		bv := dwm.bv[i].(*dynamicBitVector)
+		b, _ := bv.Get(pos)
+		next := dwm.nextPos(i, pos, b)
+		bv.delete(pos)
+		if !b {
+			dwm.zeros[i]--
+		}
+		pos = next
+	}
+	dwm.size--
+	return nil
+}
+
+// Set replaces the pos-th element with `c`.
+func (dwm *DynamicWMData) Set(pos, c uint64) error {
+	if err := dwm.checkValue(c); err != nil {
+		return err
+	}
+	if err := dwm.Delete(pos); err != nil {
+		return err
+	}
+	return dwm.Insert(pos, c)
+}
+
+// checkValue checks that `c` can be stored in the levels.
+func (dwm *DynamicWMData) checkValue(c uint64) error {
+	if c == NotFound {
+		return ErrorReservedValue
+	}
+	if dwm.alphabetBitNum < 64 && c >= uint64(1)<<dwm.alphabetBitNum {
+		return ErrorOutOfAlphabet
+	}
+	return nil
+}
+
+/*
+UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+*/
+func (dwm *DynamicWMData) UnmarshalBinary(data []byte) error {
+	_, err := dwm.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+/*
+ReadFrom implements the io.ReaderFrom interface.
+It reads the binary of any Wavelet-Matrix which is not weighted, and converts the bit vectors into dynamic ones.
+On error, the matrix is left empty.
+*/
+func (dwm *DynamicWMData) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	err := dwm.readFrom(cr, nil)
+	if err == nil && len(dwm.weightSums) > 0 {
+		err = formatError("weighted Wavelet-Matrix can not be updated")
+	}
+	if err != nil {
+		dwm.WMData = WMData{}
+		return cr.n, err
+	}
+	for i, bv := range dwm.bv {
+		if _, ok := bv.(*dynamicBitVector); ok {
+			continue
+		}
+		words := make([]uint64, (bv.Size()+63)/64)
+		for j := uint64(0); j < bv.Size(); j++ {
+			if b, _ := bv.Get(j); b {
+				words[j/64] |= uint64(1) << (j % 64)
+			}
+		}
+		dwm.bv[i] = newDynamicBitVector(words, bv.Size())
+	}
+	dwm.backend = dynamicBitVectorBackend
+	return cr.n, nil
+}
+
+func (dynamicBackend) Name() string {
+	return "dynamic"
+}
+
+// NewBuilder returns the builder of dynamic bit vector. selectIndex is ignored,
+// because Select descends the tree.
+func (dynamicBackend) NewBuilder(selectIndex bool) bitVectorBuilder {
+	return &dynamicBuilder{}
+}
+
+func (dynamicBackend) UnmarshalBitVector(data []byte) (bitVector, error) {
+	dataLen := uint64(len(data))
+	if dataLen < sizeOfInt64 || dataLen%sizeOfInt64 != 0 {
+		return nil, ErrorInvalidFormat
+	}
+	size := binary.LittleEndian.Uint64(data)
+	if size > NotFound-63 || (dataLen/sizeOfInt64)-1 != (size+63)/64 {
+		return nil, ErrorInvalidFormat
+	}
+	words := make([]uint64, (size+63)/64)
+	binary.Read(bytes.NewReader(data[sizeOfInt64:]), binary.LittleEndian, words)
+	if r := size % 64; r != 0 && words[len(words)-1]>>r != 0 {
+		return nil, ErrorInvalidFormat
+	}
+	return newDynamicBitVector(words, size), nil
+}
+
+func (b *dynamicBuilder) Set(i uint64, bit bool) {
+	for uint64(len(b.words)) <= i/64 {
+		b.words = append(b.words, 0)
+	}
+	if bit {
+		b.words[i/64] |= uint64(1) << (i % 64)
+	} else {
+		b.words[i/64] &^= uint64(1) << (i % 64)
+	}
+	if i >= b.size {
+		b.size = i + 1
+	}
+}
+
+func (b *dynamicBuilder) Build() (bitVector, error) {
+	return newDynamicBitVector(b.words, b.size), nil
+}
+
+// newDynamicBitVector builds the balanced tree whose leaves are full except the last one.
+func newDynamicBitVector(words []uint64, size uint64) *dynamicBitVector {
+	var leaves []*dynamicNode
+	for pos := uint64(0); pos < size || len(leaves) == 0; pos += dynamicLeafBits {
+		leaf := &dynamicNode{height: 1, words: make([]uint64, dynamicLeafWords)}
+		leaf.size = size - pos
+		if leaf.size > dynamicLeafBits {
+			leaf.size = dynamicLeafBits
+		}
+		copy(leaf.words, words[pos/64:(pos+leaf.size+63)/64])
+		for _, w := range leaf.words {
+			leaf.ones += uint64(bits.OnesCount64(w))
+		}
+		leaves = append(leaves, leaf)
+	}
+	return &dynamicBitVector{buildDynamicTree(leaves)}
+}
+
+// buildDynamicTree builds the tree from the leaves by splitting them in halves, so that the heights of siblings differ by at most 1.
+func buildDynamicTree(leaves []*dynamicNode) *dynamicNode {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	mid := len(leaves) / 2
+	n := &dynamicNode{left: buildDynamicTree(leaves[:mid]), right: buildDynamicTree(leaves[mid:])}
+	n.update()
+	return n
+}
+
+func (bv *dynamicBitVector) Size() uint64 {
+	return bv.root.size
+}
+
+func (bv *dynamicBitVector) NumOfBits(b bool) uint64 {
+	if b {
+		return bv.root.ones
+	}
+	return bv.root.size - bv.root.ones
+}
+
+func (bv *dynamicBitVector) Get(i uint64) (bool, error) {
+	if i >= bv.root.size {
+		return false, ErrOutOfRange
+	}
+	n := bv.root
+	for n.words == nil {
+		if i < n.left.size {
+			n = n.left
+		} else {
+			i -= n.left.size
+			n = n.right
+		}
+	}
+	return (n.words[i/64]>>(i%64))&1 == 1, nil
+}
+
+func (bv *dynamicBitVector) Rank1(i uint64) (uint64, error) {
+	if i > bv.root.size {
+		return 0, ErrOutOfRange
+	}
+	ones := uint64(0)
+	n := bv.root
+	for n.words == nil {
+		if i < n.left.size {
+			n = n.left
+		} else {
+			i -= n.left.size
+			ones += n.left.ones
+			n = n.right
+		}
+	}
+	for j := uint64(0); j < i/64; j++ {
+		ones += uint64(bits.OnesCount64(n.words[j]))
+	}
+	if r := i % 64; r != 0 {
+		ones += uint64(bits.OnesCount64(n.words[i/64] & (uint64(1)<<r - 1)))
+	}
+	return ones, nil
+}
+
+func (bv *dynamicBitVector) Rank0(i uint64) (uint64, error) {
+	ones, err := bv.Rank1(i)
+	return i - ones, err
+}
+
+func (bv *dynamicBitVector) Rank(i uint64, b bool) (uint64, error) {
+	if b {
+		return bv.Rank1(i)
+	}
+	return bv.Rank0(i)
+}
+
+// Select returns the position of the (x+1)-th b.
+func (bv *dynamicBitVector) Select(x uint64, b bool) (uint64, error) {
+	if x >= bv.NumOfBits(b) {
+		return 0, ErrRankTooLarge
+	}
+	pos := uint64(0)
+	n := bv.root
+	for n.words == nil {
+		count := n.left.ones
+		if !b {
+			count = n.left.size - n.left.ones
+		}
+		if x < count {
+			n = n.left
+		} else {
+			x -= count
+			pos += n.left.size
+			n = n.right
+		}
+	}
+	for j := uint64(0); ; j++ {
+		w := n.words[j]
+		if !b {
+			w = ^w
+		}
+		if cnt := uint64(bits.OnesCount64(w)); x >= cnt {
+			x -= cnt
+			continue
+		}
+		for ; x > 0; x-- {
+			w &= w - 1
+		}
+		return pos + j*64 + uint64(bits.TrailingZeros64(w)), nil
+	}
+}
+
+// MarshalBinary encodes the size and the bits in order.
+func (bv *dynamicBitVector) MarshalBinary() ([]byte, error) {
+	words := make([]uint64, 0, (bv.root.size+63)/64)
+	pos := uint64(0)
+	var appendLeaves func(n *dynamicNode)
+	appendLeaves = func(n *dynamicNode) {
+		if n.words == nil {
+			appendLeaves(n.left)
+			appendLeaves(n.right)
+			return
+		}
+		for j := uint64(0); j*64 < n.size; j++ {
+			width := n.size - j*64
+			if width > 64 {
+				width = 64
+			}
+			words = putBits(words, pos, n.words[j], width)
+			pos += width
+		}
+	}
+	appendLeaves(bv.root)
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, bv.root.size)
+	binary.Write(buffer, binary.LittleEndian, words)
+	return buffer.Bytes(), nil
+}
+
+func (bv *dynamicBitVector) insert(pos uint64, b bool) {
+	bv.root = bv.root.insert(pos, b)
+}
+
+func (bv *dynamicBitVector) delete(pos uint64) {
+	bv.root = bv.root.delete(pos)
+}
+
+// insert inserts b at pos of the subtree, and returns the new root of the subtree.
+func (n *dynamicNode) insert(pos uint64, b bool) *dynamicNode {
+	if n.words != nil {
+		if n.size < dynamicLeafBits {
+			insertBit(n.words, n.size, pos, b)
+			n.size++
+			if b {
+				n.ones++
+			}
+			return n
+		}
+		// Split the full leaf into two halves.
+		half := dynamicLeafWords / 2
+		left := &dynamicNode{height: 1, words: make([]uint64, dynamicLeafWords), size: half * 64}
+		right := &dynamicNode{height: 1, words: make([]uint64, dynamicLeafWords), size: n.size - half*64}
+		copy(left.words, n.words[:half])
+		copy(right.words, n.words[half:])
+		for j := uint64(0); j < half; j++ {
+			left.ones += uint64(bits.OnesCount64(left.words[j]))
+		}
+		right.ones = n.ones - left.ones
+		n = &dynamicNode{left: left, right: right}
+	}
+	if pos <= n.left.size {
+		n.left = n.left.insert(pos, b)
+	} else {
+		n.right = n.right.insert(pos-n.left.size, b)
+	}
+	n.update()
+	return n.balance()
+}
+
+// delete removes the bit at pos of the subtree, and returns the new root of the subtree.
+// A leaf which becomes less than half full is merged with its adjacent leaf, or takes bits from it,
+// so that the leaves except the root keep at least half of their bits.
+func (n *dynamicNode) delete(pos uint64) *dynamicNode {
+	if n.words != nil {
+		if (n.words[pos/64]>>(pos%64))&1 == 1 {
+			n.ones--
+		}
+		deleteBit(n.words, n.size, pos)
+		n.size--
+		return n
+	}
+	if pos < n.left.size {
+		n.left = n.left.delete(pos)
+		if n.left.words != nil && n.left.size < dynamicLeafBits/2 {
+			left, right := mergeLeaves(n.left, n.right.firstLeaf())
+			if right == nil {
+				return n.right.replaceFirst(left)
+			}
+			n.left = left
+			n.right = n.right.replaceFirst(right)
+		}
+	} else {
+		n.right = n.right.delete(pos - n.left.size)
+		if n.right.words != nil && n.right.size < dynamicLeafBits/2 {
+			left, right := mergeLeaves(n.left.lastLeaf(), n.right)
+			if right == nil {
+				return n.left.replaceLast(left)
+			}
+			n.left = n.left.replaceLast(left)
+			n.right = right
+		}
+	}
+	n.update()
+	return n.balance()
+}
+
+// mergeLeaves returns the leaf holding the bits of l followed by the bits of r, and nil if they fit in a leaf.
+// Otherwise, the bits are split into two leaves of at least half full.
+func mergeLeaves(l, r *dynamicNode) (*dynamicNode, *dynamicNode) {
+	words := make([]uint64, 2*dynamicLeafWords)
+	copy(words, l.words)
+	for pos := uint64(0); pos < r.size; pos += 64 {
+		width := r.size - pos
+		if width > 64 {
+			width = 64
+		}
+		putBits(words, l.size+pos, r.words[pos/64], width)
+	}
+	size := l.size + r.size
+	if size <= dynamicLeafBits {
+		return newDynamicLeaf(words, 0, size), nil
+	}
+	half := size / 2
+	return newDynamicLeaf(words, 0, half), newDynamicLeaf(words, half, size-half)
+}
+
+// newDynamicLeaf returns the leaf holding `size` bits of words from pos.
+func newDynamicLeaf(words []uint64, pos, size uint64) *dynamicNode {
+	leaf := &dynamicNode{height: 1, words: make([]uint64, dynamicLeafWords), size: size}
+	for i := uint64(0); i < size; i += 64 {
+		width := size - i
+		if width > 64 {
+			width = 64
+		}
+		leaf.words[i/64] = getBits(words, pos+i, width)
+		leaf.ones += uint64(bits.OnesCount64(leaf.words[i/64]))
+	}
+	return leaf
+}
+
+func (n *dynamicNode) firstLeaf() *dynamicNode {
+	for n.words == nil {
+		n = n.left
+	}
+	return n
+}
+
+func (n *dynamicNode) lastLeaf() *dynamicNode {
+	for n.words == nil {
+		n = n.right
+	}
+	return n
+}
+
+// replaceFirst replaces the first leaf of the subtree with leaf. The heights are not changed.
+func (n *dynamicNode) replaceFirst(leaf *dynamicNode) *dynamicNode {
+	if n.words != nil {
+		return leaf
+	}
+	n.left = n.left.replaceFirst(leaf)
+	n.update()
+	return n
+}
+
+// replaceLast replaces the last leaf of the subtree with leaf. The heights are not changed.
+func (n *dynamicNode) replaceLast(leaf *dynamicNode) *dynamicNode {
+	if n.words != nil {
+		return leaf
+	}
+	n.right = n.right.replaceLast(leaf)
+	n.update()
+	return n
+}
+
+func (n *dynamicNode) update() {
+	n.size = n.left.size + n.right.size
+	n.ones = n.left.ones + n.right.ones
+	n.height = n.left.height + 1
+	if n.right.height >= n.left.height {
+		n.height = n.right.height + 1
+	}
+}
+
+// balance restores the balance of the internal node n by rotations, and returns the new root of the subtree.
+func (n *dynamicNode) balance() *dynamicNode {
+	switch diff := n.left.height - n.right.height; {
+	case diff > 1:
+		if n.left.left.height < n.left.right.height {
+			n.left = n.left.rotateLeft()
+		}
+		return n.rotateRight()
+	case diff < -1:
+		if n.right.right.height < n.right.left.height {
+			n.right = n.right.rotateRight()
+		}
+		return n.rotateLeft()
+	}
+	return n
+}
+
+func (n *dynamicNode) rotateRight() *dynamicNode {
+	l := n.left
+	n.left = l.right
+	n.update()
+	l.right = n
+	l.update()
+	return l
+}
+
+func (n *dynamicNode) rotateLeft() *dynamicNode {
+	r := n.right
+	n.right = r.left
+	n.update()
+	r.left = n
+	r.update()
+	return r
+}
+
+// insertBit inserts b at pos of the size bits in words, shifting the following bits.
+func insertBit(words []uint64, size, pos uint64, b bool) {
+	w, off := pos/64, pos%64
+	carry := words[w] >> 63
+	low := words[w] & (uint64(1)<<off - 1)
+	words[w] = low | (words[w]&^(uint64(1)<<off-1))<<1
+	if b {
+		words[w] |= uint64(1) << off
+	}
+	for j := w + 1; j <= size/64; j++ {
+		next := words[j] >> 63
+		words[j] = words[j]<<1 | carry
+		carry = next
+	}
+}
+
+// deleteBit removes the bit at pos of the size bits in words, shifting the following bits.
+func deleteBit(words []uint64, size, pos uint64) {
+	w, off := pos/64, pos%64
+	low := words[w] & (uint64(1)<<off - 1)
+	words[w] = low | (words[w]>>1)&^(uint64(1)<<off-1)
+	for j := w + 1; j <= (size-1)/64; j++ {
+		words[j-1] |= (words[j] & 1) << 63
+		words[j] >>= 1
+	}
+}
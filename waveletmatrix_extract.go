@@ -0,0 +1,225 @@
+package waveletmatrix
+
+// rangeSegment is a run of consecutive positions of a level, which holds a part of the elements of a subarray.
+type rangeSegment struct {
+	begPos uint64
+	length uint64
+}
+
+// Extract returns the subarray A[begPos ... endPos).
+// The values are decoded level by level, so that each level is ranked only at the boundaries of the segments,
+// instead of descending all the levels for each position like Lookup.
+// If the range is invalid, nil is returned.
+func (wm *WMData) Extract(begPos, endPos uint64) []uint64 {
+	if checkPosRange(begPos, endPos, wm.size) != nil {
+		return nil
+	}
+	n := endPos - begPos
+	values := make([]uint64, n)
+	// order[k] is the index in values of the k-th element of the segments.
+	order := make([]uint64, n)
+	next := make([]uint64, n)
+	for k := range order {
+		order[k] = uint64(k)
+	}
+	segs := []rangeSegment{{begPos, n}}
+	for i := uint64(0); i < wm.alphabetBitNum; i++ {
+		childSegs, zeros := wm.childSegments(i, segs)
+		k := uint64(0)
+		zeroPos := uint64(0)
+		onePos := zeros
+		for _, seg := range segs {
+			for j := seg.begPos; j < seg.begPos+seg.length; j++ {
+				b, _ := wm.bv[i].Get(j)
+				index := order[k]
+				values[index] <<= 1
+				if b {
+					values[index] |= 1
+					next[onePos] = index
+					onePos++
+				} else {
+					next[zeroPos] = index
+					zeroPos++
+				}
+				k++
+			}
+		}
+		segs = childSegs
+		order, next = next, order
+	}
+	return values
+}
+
+// Slice returns Wavelet-Matrix of the subarray A[begPos ... endPos), which is equivalent to NewWM of the subarray.
+// The bits of the levels are copied from wm without decoding the values. The levels of only 0 bits at the top are
+// dropped, so that the alphabet is shrunk to the largest value of the subarray. The weights are also sliced if wm is weighted.
+// If the range is invalid, ErrOutOfRange is returned, and if the range is empty, ErrorEmpty is returned.
+func (wm *WMData) Slice(begPos, endPos uint64) (WaveletMatrix, error) {
+	if err := checkPosRange(begPos, endPos, wm.size); err != nil {
+		return nil, err
+	}
+	if begPos == endPos {
+		return nil, ErrorEmpty
+	}
+	_, maxVal := wm.quantileRange(begPos, endPos, endPos-begPos-1)
+	slice := &WMData{size: endPos - begPos, alphabetNum: maxVal + 1, backend: wm.levelBackend()}
+	slice.alphabetBitNum = levelNumOf(slice.alphabetNum)
+	slice.bv = make([]bitVector, slice.alphabetBitNum)
+	slice.zeros = make([]uint64, slice.alphabetBitNum)
+	if wm.weightSums != nil {
+		slice.weightSums = make([][]uint64, slice.alphabetBitNum+1)
+	}
+
+	// The elements of the subarray stay in one segment through the dropped levels.
+	segs := []rangeSegment{{begPos, endPos - begPos}}
+	skip := wm.alphabetBitNum - slice.alphabetBitNum
+	for i := uint64(0); i < skip; i++ {
+		segs, _ = wm.childSegments(i, segs)
+	}
+	for i := uint64(0); ; i++ {
+		if wm.weightSums != nil {
+			slice.weightSums[i] = sliceWeightSums(wm.weightSums[skip+i], segs, slice.size)
+		}
+		if i == slice.alphabetBitNum {
+			break
+		}
+		bvBuilder := slice.backend.NewBuilder(true)
+		pos := uint64(0)
+		for _, seg := range segs {
+			for j := seg.begPos; j < seg.begPos+seg.length; j++ {
+				b, _ := wm.bv[skip+i].Get(j)
+				bvBuilder.Set(pos, b)
+				pos++
+			}
+		}
+		bv, err := bvBuilder.Build()
+		if err != nil {
+			return nil, err
+		}
+		slice.bv[i] = bv
+		segs, slice.zeros[i] = wm.childSegments(skip+i, segs)
+	}
+	return slice, nil
+}
+
+// childSegments returns the segments of the level i+1 which hold the elements of segs in the level i, and the number
+// of 0 bits in segs. The elements of 0 bits of the segments come first, followed by the elements of 1 bits.
+func (wm *WMData) childSegments(i uint64, segs []rangeSegment) ([]rangeSegment, uint64) {
+	zeroSegs := make([]rangeSegment, 0, len(segs)*2)
+	oneSegs := make([]rangeSegment, 0, len(segs))
+	zeros := uint64(0)
+	for _, seg := range segs {
+		begZero, _ := wm.bv[i].Rank0(seg.begPos)
+		endZero, _ := wm.bv[i].Rank0(seg.begPos + seg.length)
+		if endZero > begZero {
+			zeroSegs = append(zeroSegs, rangeSegment{begZero, endZero - begZero})
+		}
+		if ones := seg.length - (endZero - begZero); ones > 0 {
+			oneSegs = append(oneSegs, rangeSegment{wm.zeros[i] + seg.begPos - begZero, ones})
+		}
+		zeros += endZero - begZero
+	}
+	return append(zeroSegs, oneSegs...), zeros
+}
+
+// sliceWeightSums returns the cumulative weights of the elements of segs, whose cumulative weights in the level are sums.
+func sliceWeightSums(sums []uint64, segs []rangeSegment, size uint64) []uint64 {
+	sliced := make([]uint64, 1, size+1)
+	for _, seg := range segs {
+		total := sliced[len(sliced)-1]
+		for j := seg.begPos; j < seg.begPos+seg.length; j++ {
+			sliced = append(sliced, total+sums[j+1]-sums[seg.begPos])
+		}
+	}
+	return sliced
+}
+
+// Extract returns the subarray A[begPos ... endPos).
+// If the range is invalid, nil is returned.
+func (cwm *CompactWMData) Extract(begPos, endPos uint64) []uint64 {
+	values := cwm.wm.Extract(begPos, endPos)
+	for i, r := range values {
+		values[i] = cwm.values[r]
+	}
+	return values
+}
+
+// Slice returns compact Wavelet-Matrix of the subarray A[begPos ... endPos).
+// The values larger than the largest value of the subarray are dropped, and the others are kept even if they do not occur in it.
+// If the range is invalid, ErrOutOfRange is returned, and if the range is empty, ErrorEmpty is returned.
+func (cwm *CompactWMData) Slice(begPos, endPos uint64) (WaveletMatrix, error) {
+	slice, err := cwm.wm.Slice(begPos, endPos)
+	if err != nil {
+		return nil, err
+	}
+	wm := slice.(*WMData)
+	values := make([]uint64, wm.alphabetNum)
+	copy(values, cwm.values)
+	return &CompactWMData{values: values, wm: wm}, nil
+}
+
+// Extract returns the subarray A[begPos ... endPos).
+// The symbols are decoded level by level in the same way as WMData.
+// If the range is invalid, nil is returned.
+func (mwm *MultiaryWMData) Extract(begPos, endPos uint64) []uint64 {
+	if checkPosRange(begPos, endPos, mwm.size) != nil {
+		return nil
+	}
+	n := endPos - begPos
+	values := make([]uint64, n)
+	order := make([]uint64, n)
+	next := make([]uint64, n)
+	for k := range order {
+		order[k] = uint64(k)
+	}
+	symbolNum := uint64(1) << mwm.symbolBitNum
+	segs := []rangeSegment{{begPos, n}}
+	for i := uint64(0); i < uint64(len(mwm.levels)); i++ {
+		// The elements of the symbol s are placed after pos[s] in order, and form childSegs[s] in the next level.
+		childSegs := make([][]rangeSegment, symbolNum)
+		pos := make([]uint64, symbolNum+1)
+		for _, seg := range segs {
+			for s := uint64(0); s < symbolNum; s++ {
+				beg := mwm.nextPos(i, seg.begPos, s)
+				end := mwm.nextPos(i, seg.begPos+seg.length, s)
+				if end > beg {
+					childSegs[s] = append(childSegs[s], rangeSegment{beg, end - beg})
+					pos[s+1] += end - beg
+				}
+			}
+		}
+		for s := uint64(1); s < symbolNum; s++ {
+			pos[s] += pos[s-1]
+		}
+		seq := &mwm.levels[i]
+		k := uint64(0)
+		for _, seg := range segs {
+			for j := seg.begPos; j < seg.begPos+seg.length; j++ {
+				s := seq.get(j)
+				index := order[k]
+				values[index] = values[index]<<mwm.symbolBitNum | s
+				next[pos[s]] = index
+				pos[s]++
+				k++
+			}
+		}
+		segs = segs[:0]
+		for _, ss := range childSegs {
+			segs = append(segs, ss...)
+		}
+		order, next = next, order
+	}
+	return values
+}
+
+// Slice returns multi-ary Wavelet-Matrix of the subarray A[begPos ... endPos), which is built from the values of Extract.
+// If the range is invalid, ErrOutOfRange is returned, and if the range is empty, ErrorEmpty is returned.
+func (mwm *MultiaryWMData) Slice(begPos, endPos uint64) (WaveletMatrix, error) {
+	if err := checkPosRange(begPos, endPos, mwm.size); err != nil {
+		return nil, err
+	}
+	if begPos == endPos {
+		return nil, ErrorEmpty
+	}
+	return NewMultiaryWM(mwm.Extract(begPos, endPos), mwm.symbolBitNum)
+}
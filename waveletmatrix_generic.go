@@ -0,0 +1,259 @@
+package waveletmatrix
+
+import (
+	"io"
+	"unsafe"
+)
+
+// Integer is a constraint that permits any integer type.
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// Matrix is Wavelet-Matrix over the values of the integer type T.
+// Signed values are mapped to unsigned ones by an order-preserving bias,
+// so that value-ordered queries(RankLessThan, QuantileRange, ListMinRange, ...) work as expected.
+// The largest value of a 64-bit type can not be stored, because it is mapped to NotFound.
+type Matrix[T Integer] struct {
+	wm      WaveletMatrix
+	signBit uint64
+	mask    uint64
+}
+
+// TypedListResult is result of list* API of Matrix
+type TypedListResult[T Integer] struct {
+	// The character
+	C T
+	// The frequency of c in the array
+	Freq uint64
+}
+
+// New builds Wavelet-Matrix from the array of any integer type.
+func New[T Integer](src []T) (*Matrix[T], error) {
+	m := newMatrix[T]()
+	keys := make([]uint64, len(src))
+	for i := 0; i < len(src); i++ {
+		keys[i] = m.toKey(src[i])
+	}
+	builder := newWMBuilder(nil)
+	wm, err := builder.build(keys)
+	if err != nil {
+		return nil, err
+	}
+	m.wm = wm
+	return m, nil
+}
+
+// NewFromBinary restores Matrix from the binary created by MarshalBinary.
+func NewFromBinary[T Integer](data []byte) (*Matrix[T], error) {
+	m := newMatrix[T]()
+	err := m.UnmarshalBinary(data)
+	return m, err
+}
+
+func newMatrix[T Integer]() *Matrix[T] {
+	var zero T
+	bitNum := uint64(unsafe.Sizeof(zero)) * 8
+	m := &Matrix[T]{mask: NotFound >> (64 - bitNum)}
+	if minusOne := zero - 1; minusOne < zero {
+		m.signBit = uint64(1) << (bitNum - 1)
+	}
+	return m
+}
+
+func (m *Matrix[T]) toKey(c T) uint64 {
+	return (uint64(c) ^ m.signBit) & m.mask
+}
+
+func (m *Matrix[T]) fromKey(key uint64) T {
+	return T(key ^ m.signBit)
+}
+
+// Size returns size of wavelet-matrix
+func (m *Matrix[T]) Size() uint64 {
+	return m.wm.Size()
+}
+
+// Lookup element by pos.
+// This function returns value of pos-th element of wavelet-matrix.
+// if pos >= (size of wavelet-matrix),  value of second result parameter is false.
+func (m *Matrix[T]) Lookup(pos uint64) (T, bool) {
+	key, found := m.wm.Lookup(pos)
+	if !found {
+		return 0, false
+	}
+	return m.fromKey(key), true
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (m *Matrix[T]) Rank(c T, pos uint64) (uint64, bool) {
+	return m.wm.Rank(m.toKey(c), pos)
+}
+
+// RankAll returns the frequency of characters c' < c, c'=c, and c' > c, in the subarray A[begPos...endPos)
+// c may be larger than every value in the array, and then all the characters are less than c.
+func (m *Matrix[T]) RankAll(c T, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
+	return m.rankAll(m.toKey(c), beginPos, endPos)
+}
+
+// RankLessThan returns the frequency of characters c' < c in the subarray A[0...pos)
+func (m *Matrix[T]) RankLessThan(c T, pos uint64) uint64 {
+	_, rank, _ := m.rankAll(m.toKey(c), 0, pos)
+	return rank
+}
+
+// RankMoreThan returns the frequency of characters c' > c in the subarray A[0...pos)
+func (m *Matrix[T]) RankMoreThan(c T, pos uint64) uint64 {
+	_, _, rank := m.rankAll(m.toKey(c), 0, pos)
+	return rank
+}
+
+// rankAll is RankAll of the key. The key not less than the alphabet size is not in the array,
+// but is larger than every key in it, as the bound of FreqRange is.
+func (m *Matrix[T]) rankAll(key, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
+	rank, rankLessThan, rankMoreThan, err := m.wm.Checked().RankAll(key, beginPos, endPos)
+	if err == ErrInvalidSymbol && checkPosRange(beginPos, endPos, m.wm.Size()) == nil {
+		return 0, endPos - beginPos, 0
+	}
+	return
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (m *Matrix[T]) Select(c T, rank uint64) (uint64, bool) {
+	return m.wm.Select(m.toKey(c), rank)
+}
+
+// SelectFromPos returns the position of the rank-th occurrence of `c` in the suffix of the array starting from 'pos'
+func (m *Matrix[T]) SelectFromPos(c T, pos, rank uint64) (uint64, bool) {
+	return m.wm.SelectFromPos(m.toKey(c), pos, rank)
+}
+
+// Freq returns the frequency of the character `c`.
+func (m *Matrix[T]) Freq(c T) uint64 {
+	return m.wm.Freq(m.toKey(c))
+}
+
+// FreqSum returns frequency of the characters(minC <= c' < maxC)
+func (m *Matrix[T]) FreqSum(minC, maxC T) uint64 {
+	return m.wm.FreqSum(m.toKey(minC), m.toKey(maxC))
+}
+
+// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
+func (m *Matrix[T]) FreqRange(minC, maxC T, begPos, endPos uint64) uint64 {
+	return m.wm.FreqRange(m.toKey(minC), m.toKey(maxC), begPos, endPos)
+}
+
+// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
+// If the arguments are invalid, pos is NotFound.
+func (m *Matrix[T]) QuantileRange(begPos, endPos, k uint64) (pos uint64, val T) {
+	pos, key := m.wm.QuantileRange(begPos, endPos, k)
+	if pos == NotFound {
+		return
+	}
+	val = m.fromKey(key)
+	return
+}
+
+// MaxRange returns maximum value(and position) in the subarray A[begPos .. endPos]
+func (m *Matrix[T]) MaxRange(begPos, endPos uint64) (pos uint64, val T) {
+	pos, val = m.QuantileRange(begPos, endPos, endPos-begPos-uint64(1))
+	return
+}
+
+// MinRange returns minimum value(and position) in the subarray A[begPos .. endPos]
+func (m *Matrix[T]) MinRange(begPos, endPos uint64) (pos uint64, val T) {
+	pos, val = m.QuantileRange(begPos, endPos, 0)
+	return
+}
+
+// NextValue returns the smallest value c >= x(and the position of its first occurrence) in the subarray A[begPos ... endPos)
+// If there is no such value, pos is NotFound.
+func (m *Matrix[T]) NextValue(begPos, endPos uint64, x T) (pos uint64, val T) {
+	pos, key := m.wm.NextValue(begPos, endPos, m.toKey(x))
+	if pos == NotFound {
+		return
+	}
+	val = m.fromKey(key)
+	return
+}
+
+// PrevValue returns the largest value c < x(and the position of its last occurrence) in the subarray A[begPos ... endPos)
+// If there is no such value, pos is NotFound.
+func (m *Matrix[T]) PrevValue(begPos, endPos uint64, x T) (pos uint64, val T) {
+	pos, key := m.wm.PrevValue(begPos, endPos, m.toKey(x))
+	if pos == NotFound {
+		return
+	}
+	val = m.fromKey(key)
+	return
+}
+
+// ListModeRange returns list of the distinct characters appeared in A[begPos ... endPos) from most frequent ones.
+func (m *Matrix[T]) ListModeRange(minC, maxC T, begPos, endPos, num uint64) []TypedListResult[T] {
+	return m.toTypedList(m.wm.ListModeRange(m.toKey(minC), m.toKey(maxC), begPos, endPos, num))
+}
+
+// ListMinRange returns list of the distinct characters in A[begPos ... endPos) minC <= c < maxC  from smallest ones.
+func (m *Matrix[T]) ListMinRange(minC, maxC T, begPos, endPos, num uint64) []TypedListResult[T] {
+	return m.toTypedList(m.wm.ListMinRange(m.toKey(minC), m.toKey(maxC), begPos, endPos, num))
+}
+
+// ListMaxRange returns list of the distinct characters appeared in A[begPos ... endPos) from largest ones.
+func (m *Matrix[T]) ListMaxRange(minC, maxC T, begPos, endPos, num uint64) []TypedListResult[T] {
+	return m.toTypedList(m.wm.ListMaxRange(m.toKey(minC), m.toKey(maxC), begPos, endPos, num))
+}
+
+func (m *Matrix[T]) toTypedList(res []ListResult) []TypedListResult[T] {
+	if res == nil {
+		return nil
+	}
+	typed := make([]TypedListResult[T], len(res))
+	for i := 0; i < len(res); i++ {
+		typed[i] = TypedListResult[T]{m.fromKey(res[i].C), res[i].Freq}
+	}
+	return typed
+}
+
+/*
+MarshalBinary implements the encoding.BinaryMarshaler interface.
+*/
+func (m *Matrix[T]) MarshalBinary() ([]byte, error) {
+	return m.wm.MarshalBinary()
+}
+
+/*
+UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+*/
+func (m *Matrix[T]) UnmarshalBinary(data []byte) error {
+	wm := new(WMData)
+	if err := wm.UnmarshalBinary(data); err != nil {
+		return err
+	}
+	m.wm = wm
+	return nil
+}
+
+/*
+WriteTo implements the io.WriterTo interface.
+*/
+func (m *Matrix[T]) WriteTo(w io.Writer) (int64, error) {
+	return m.wm.WriteTo(w)
+}
+
+/*
+ReadFrom implements the io.ReaderFrom interface.
+*/
+func (m *Matrix[T]) ReadFrom(r io.Reader) (int64, error) {
+	wm := new(WMData)
+	n, err := wm.ReadFrom(r)
+	if err != nil {
+		return n, err
+	}
+	m.wm = wm
+	return n, nil
+}
+
+// Validate checks that the underlying Wavelet-Matrix is internally consistent.
+func (m *Matrix[T]) Validate(opts ...ValidateOption) error {
+	return m.wm.Validate(opts...)
+}
@@ -0,0 +1,316 @@
+package waveletmatrix
+
+import (
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/hideo55/go-pq"
+)
+
+// HuffmanWaveletMatrix is interface of Huffman-shaped Wavelet-Matrix.
+// Each value is encoded by its Huffman code, so that frequent values have short codes, and both the space
+// and the average time of Lookup and Rank are proportional to the entropy of the array.
+//
+// The codes do not preserve the order of the values, so that the value-ordered queries
+// (RankAll, RankLessThan, RankMoreThan, FreqSum, FreqRange, QuantileRange, MaxRange, MinRange, NextValue, PrevValue
+// and List*Range) are not supported.
+type HuffmanWaveletMatrix interface {
+	Size() uint64
+	Lookup(pos uint64) (uint64, bool)
+	Rank(c, pos uint64) (uint64, bool)
+	Select(c, rank uint64) (uint64, bool)
+	SelectFromPos(c, pos, rank uint64) (uint64, bool)
+	Freq(c uint64) uint64
+}
+
+// HuffmanWMData holds information of Huffman-shaped Wavelet-Matrix.
+// Level i holds the bits of the elements whose codes are longer than i. The codes are assigned so that,
+// in every level, the elements whose codes end at the level are placed after the others in the next level.
+// Thus, the elements of the next level are the prefix of the level, and positions move between levels
+// in the same manner as WMData.
+type HuffmanWMData struct {
+	size uint64
+	bv   []bitVector
+	// zeros holds the number of 0 bits in each level.
+	zeros []uint64
+	codes map[uint64]huffmanCode
+	// values maps the code to the value.
+	values map[huffmanCode]uint64
+	// minLeafRev[i] is the smallest bit-reversed code of length i+1. A prefix of length i+1 is a code
+	// if and only if its bit-reversed value is not less than minLeafRev[i].
+	minLeafRev []uint64
+}
+
+type huffmanCode struct {
+	code   uint64
+	length uint64
+}
+
+// huffmanNode is a node of Huffman tree during the computation of code lengths.
+type huffmanNode struct {
+	weight uint64
+	id     int
+}
+
+var (
+	// ErrorCodeTooLong indicates that a Huffman code exceeds 64 bits.
+	ErrorCodeTooLong = errors.New("Huffman code is longer than 64 bits.")
+)
+
+// NewHuffmanWM builds Huffman-shaped Wavelet-Matrix from src. The values may be sparse.
+// The codes are canonical, that is, they are determined only by the lengths of Huffman codes.
+// Of opts, only WithSelectIndex and WithBitVectorBackend are applied.
+func NewHuffmanWM(src []uint64, opts ...Option) (HuffmanWaveletMatrix, error) {
+	if len(src) == 0 {
+		return nil, ErrorEmpty
+	}
+	freqs := make(map[uint64]uint64)
+	for _, c := range src {
+		if c == NotFound {
+			return nil, ErrorReservedValue
+		}
+		freqs[c]++
+	}
+	hwm := &HuffmanWMData{size: uint64(len(src))}
+	if err := hwm.assignCodes(freqs); err != nil {
+		return nil, err
+	}
+	if err := hwm.buildLevels(src, newWMBuilder(opts)); err != nil {
+		return nil, err
+	}
+	return hwm, nil
+}
+
+// huffmanLengths returns the lengths of Huffman codes of the values, which are sorted in ascending order.
+func huffmanLengths(values []uint64, freqs map[uint64]uint64) []uint64 {
+	lengths := make([]uint64, len(values))
+	if len(values) == 1 {
+		lengths[0] = 1
+		return lengths
+	}
+	// Nodes are created in the order of merging, so that the parent of a node is created after it.
+	parents := make([]int, len(values), 2*len(values)-1)
+	q := pq.NewPriorityQueue(func(a, b interface{}) bool {
+		lhs := a.(*huffmanNode)
+		rhs := b.(*huffmanNode)
+		if lhs.weight != rhs.weight {
+			return lhs.weight > rhs.weight
+		}
+		return lhs.id > rhs.id
+	})
+	for i, c := range values {
+		q.Push(&huffmanNode{freqs[c], i})
+	}
+	for {
+		lhs := q.Pop().(*huffmanNode)
+		if q.Empty() {
+			break
+		}
+		rhs := q.Pop().(*huffmanNode)
+		id := len(parents)
+		parents = append(parents, -1)
+		parents[lhs.id] = id
+		parents[rhs.id] = id
+		q.Push(&huffmanNode{lhs.weight + rhs.weight, id})
+	}
+	depths := make([]uint64, len(parents))
+	for i := len(parents) - 2; i >= 0; i-- {
+		depths[i] = depths[parents[i]] + 1
+	}
+	copy(lengths, depths)
+	return lengths
+}
+
+// assignCodes assigns the codes of the lengths of Huffman codes. At each length, the prefixes which are extended
+// from the shorter ones are sorted by their bit-reversed values, and the largest ones become the codes of the length.
+func (hwm *HuffmanWMData) assignCodes(freqs map[uint64]uint64) error {
+	values := make([]uint64, 0, len(freqs))
+	for c := range freqs {
+		values = append(values, c)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	lengths := huffmanLengths(values, freqs)
+
+	maxLength := uint64(0)
+	byLength := make(map[uint64][]uint64)
+	for i, c := range values {
+		byLength[lengths[i]] = append(byLength[lengths[i]], c)
+		if lengths[i] > maxLength {
+			maxLength = lengths[i]
+		}
+	}
+	if maxLength > 64 {
+		return ErrorCodeTooLong
+	}
+
+	hwm.codes = make(map[uint64]huffmanCode, len(values))
+	hwm.values = make(map[huffmanCode]uint64, len(values))
+	hwm.minLeafRev = make([]uint64, maxLength)
+	internal := []uint64{0}
+	for length := uint64(1); length <= maxLength; length++ {
+		candidates := make([]uint64, 0, 2*len(internal))
+		for _, prefix := range internal {
+			candidates = append(candidates, prefix<<1, prefix<<1|1)
+		}
+		sort.Slice(candidates, func(i, j int) bool {
+			return reverseCode(candidates[i], length) < reverseCode(candidates[j], length)
+		})
+		leaves := byLength[length]
+		split := len(candidates) - len(leaves)
+		hwm.minLeafRev[length-1] = NotFound
+		if len(leaves) > 0 {
+			hwm.minLeafRev[length-1] = reverseCode(candidates[split], length)
+		}
+		for i, c := range leaves {
+			code := huffmanCode{candidates[split+i], length}
+			hwm.codes[c] = code
+			hwm.values[code] = c
+		}
+		internal = candidates[:split]
+	}
+	return nil
+}
+
+// buildLevels builds the levels from src. In each level, the elements whose codes continue are partitioned stably
+// by the bit, and the elements whose codes end at the level are placed after them.
+func (hwm *HuffmanWMData) buildLevels(src []uint64, builder *wmBuilderData) error {
+	levels := uint64(len(hwm.minLeafRev))
+	hwm.bv = make([]bitVector, levels)
+	hwm.zeros = make([]uint64, levels)
+	cur := make([]uint64, len(src))
+	copy(cur, src)
+	next := make([]uint64, len(src))
+	for i := uint64(0); i < levels; i++ {
+		bvBuilder := builder.backend.NewBuilder(builder.selectIndex)
+		zeros := uint64(0)
+		for j, c := range cur {
+			code := hwm.codes[c]
+			bit := (code.code >> (code.length - i - 1)) & 1
+			bvBuilder.Set(uint64(j), toBool(bit))
+			if bit == 0 {
+				zeros++
+			}
+		}
+		bv, err := bvBuilder.Build()
+		if err != nil {
+			return err
+		}
+		hwm.bv[i] = bv
+		hwm.zeros[i] = zeros
+
+		zeroPos, onePos := 0, 0
+		for _, c := range cur {
+			if code := hwm.codes[c]; code.length > i+1 && (code.code>>(code.length-i-1))&1 == 0 {
+				next[zeroPos] = c
+				zeroPos++
+			}
+		}
+		for _, c := range cur {
+			if code := hwm.codes[c]; code.length > i+1 && (code.code>>(code.length-i-1))&1 == 1 {
+				next[zeroPos+onePos] = c
+				onePos++
+			}
+		}
+		cur, next = next[:zeroPos+onePos], cur[:cap(cur)]
+	}
+	return nil
+}
+
+// Size returns size of wavelet-matrix
+func (hwm *HuffmanWMData) Size() uint64 {
+	return hwm.size
+}
+
+// Lookup returns value of pos-th element of wavelet-matrix.
+// if pos >= (size of wavelet-matrix),  value of second result parameter is false.
+func (hwm *HuffmanWMData) Lookup(pos uint64) (uint64, bool) {
+	if pos >= hwm.size {
+		return NotFound, false
+	}
+	code := uint64(0)
+	for i := uint64(0); i < uint64(len(hwm.bv)); i++ {
+		b, _ := hwm.bv[i].Get(pos)
+		code <<= 1
+		if b {
+			code |= 1
+		}
+		if reverseCode(code, i+1) >= hwm.minLeafRev[i] {
+			return hwm.values[huffmanCode{code, i + 1}], true
+		}
+		pos = hwm.nextPos(i, pos, b)
+	}
+	return NotFound, false
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (hwm *HuffmanWMData) Rank(c, pos uint64) (uint64, bool) {
+	if pos > hwm.size {
+		return NotFound, false
+	}
+	code, found := hwm.codes[c]
+	if !found {
+		return 0, true
+	}
+	begPos, endPos := hwm.codeRange(code, 0, pos)
+	return endPos - begPos, true
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (hwm *HuffmanWMData) Select(c, rank uint64) (uint64, bool) {
+	return hwm.SelectFromPos(c, 0, rank)
+}
+
+// SelectFromPos returns the position of the rank-th occurrence of `c` in the suffix of the array starting from 'pos'
+func (hwm *HuffmanWMData) SelectFromPos(c, pos, rank uint64) (uint64, bool) {
+	code, found := hwm.codes[c]
+	if !found || pos > hwm.size || rank == 0 {
+		return NotFound, false
+	}
+	begPos, endPos := hwm.codeRange(code, pos, hwm.size)
+	if endPos-begPos < rank {
+		return NotFound, false
+	}
+	index := begPos + rank - 1
+	for i := int(code.length) - 1; i >= 0; i-- {
+		b := toBool((code.code >> (code.length - uint64(i) - 1)) & 1)
+		if b {
+			index -= hwm.zeros[i]
+		}
+		var err error
+		if index, err = hwm.bv[i].Select(index, b); err != nil {
+			return NotFound, false
+		}
+	}
+	return index, true
+}
+
+// Freq returns the frequency of the character `c`.
+func (hwm *HuffmanWMData) Freq(c uint64) uint64 {
+	freq, _ := hwm.Rank(c, hwm.size)
+	return freq
+}
+
+// codeRange maps the range [begPos, endPos) of the array to the range of the elements of code
+// after the last level of the code.
+func (hwm *HuffmanWMData) codeRange(code huffmanCode, begPos, endPos uint64) (uint64, uint64) {
+	for i := uint64(0); i < code.length; i++ {
+		b := toBool((code.code >> (code.length - i - 1)) & 1)
+		begPos = hwm.nextPos(i, begPos, b)
+		endPos = hwm.nextPos(i, endPos, b)
+	}
+	return begPos, endPos
+}
+
+func (hwm *HuffmanWMData) nextPos(i, pos uint64, b bool) uint64 {
+	next, _ := hwm.bv[i].Rank(pos, b)
+	if b {
+		next += hwm.zeros[i]
+	}
+	return next
+}
+
+// reverseCode returns code of length bits in reversed order.
+func reverseCode(code, length uint64) uint64 {
+	return bits.Reverse64(code) >> (64 - length)
+}
@@ -0,0 +1,627 @@
+package waveletmatrix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math/bits"
+)
+
+// crc32cTable is the table of CRC32C(Castagnoli) used for the checksum of the binary.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// countingWriter counts the bytes written to w, and keeps the first error.
+// If crc is not nil, the bytes are also added to the checksum.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+	crc hash.Hash32
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+	if cw.crc != nil {
+		cw.crc.Write(p[:n])
+	}
+	return n, err
+}
+
+func (cw *countingWriter) writeUint64(v uint64) {
+	binary.Write(cw, binary.LittleEndian, v)
+}
+
+func (cw *countingWriter) writeUint64s(v []uint64) {
+	cw.writeUint64(uint64(len(v)))
+	binary.Write(cw, binary.LittleEndian, v)
+}
+
+// countingReader counts the bytes read from r.
+// If crc is not nil, the bytes are also added to the checksum.
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	crc hash.Hash32
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if cr.crc != nil {
+		cr.crc.Write(p[:n])
+	}
+	return n, err
+}
+
+func (cr *countingReader) readUint32() (uint32, error) {
+	var buf [sizeOfInt32]byte
+	if _, err := io.ReadFull(cr, buf[:]); err != nil {
+		return 0, toFormatError(err)
+	}
+	return binary.LittleEndian.Uint32(buf[:]), nil
+}
+
+func (cr *countingReader) readUint64() (uint64, error) {
+	var buf [sizeOfInt64]byte
+	if _, err := io.ReadFull(cr, buf[:]); err != nil {
+		return 0, toFormatError(err)
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+func (cr *countingReader) readUint64s() ([]uint64, error) {
+	arrayLen, err := cr.readUint64()
+	if err != nil {
+		return nil, err
+	}
+	array := make([]uint64, 0)
+	// Read by blocks so that a corrupted length does not allocate a huge array before reaching the end of data.
+	const blockLen = 1 << 16
+	for uint64(len(array)) < arrayLen {
+		n := arrayLen - uint64(len(array))
+		if n > blockLen {
+			n = blockLen
+		}
+		block := make([]uint64, n)
+		if err := binary.Read(cr, binary.LittleEndian, block); err != nil {
+			return nil, toFormatError(err)
+		}
+		array = append(array, block...)
+	}
+	return array, nil
+}
+
+// readBytes reads n bytes by blocks, so that a corrupted length does not allocate a huge buffer before reaching the end of data.
+func (cr *countingReader) readBytes(n uint64) ([]byte, error) {
+	const blockLen = 1 << 20
+	buf := make([]byte, 0)
+	for uint64(len(buf)) < n {
+		m := n - uint64(len(buf))
+		if m > blockLen {
+			m = blockLen
+		}
+		block := make([]byte, m)
+		if _, err := io.ReadFull(cr, block); err != nil {
+			return nil, toFormatError(err)
+		}
+		buf = append(buf, block...)
+	}
+	return buf, nil
+}
+
+func (cr *countingReader) skip(n uint64) error {
+	copied, err := io.CopyN(io.Discard, cr, int64(n))
+	if uint64(copied) < n {
+		return formatError("unexpected end of data")
+	}
+	return err
+}
+
+// toFormatError converts the error caused by the end of data into ErrorInvalidFormat.
+func toFormatError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return formatError("unexpected end of data")
+	}
+	return err
+}
+
+// formatError returns ErrorInvalidFormat annotated with the description of the broken structure.
+func formatError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrorInvalidFormat}, args...)...)
+}
+
+// NewWMFromReader restores Wavelet-Matrix from r, which provides the binary created by WriteTo or MarshalBinary.
+func NewWMFromReader(r io.Reader) (WaveletMatrix, error) {
+	wm := new(WMData)
+	_, err := wm.ReadFrom(r)
+	return wm, err
+}
+
+/*
+WriteTo implements the io.WriterTo interface.
+It writes the same binary as MarshalBinary, streaming each level.
+
+The binary starts with the magic bytes, the format version and the flags, and ends with CRC32C checksum of the preceding bytes.
+*/
+func (wm *WMData) WriteTo(w io.Writer) (int64, error) {
+	return wm.writeTo(w, 0, nil)
+}
+
+// writeTo writes the binary of wm with flags. If flags has formatFlagCompact, values are written before the levels.
+func (wm *WMData) writeTo(w io.Writer, flags uint32, values []uint64) (int64, error) {
+	if len(wm.weightSums) > 0 {
+		flags |= formatFlagWeights
+	}
+	backend := wm.levelBackend()
+	if backend.Name() != defaultBitVectorBackend.Name() {
+		if uint64(len(backend.Name())) > maxBackendNameLen {
+			return 0, ErrorInvalidBackendName
+		}
+		flags |= formatFlagBackend
+	}
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw, crc: crc32.New(crc32cTable)}
+	cw.Write(formatMagic[:])
+	binary.Write(cw, binary.LittleEndian, formatVersion)
+	binary.Write(cw, binary.LittleEndian, flags)
+	if flags&formatFlagBackend != 0 {
+		cw.writeUint64(uint64(len(backend.Name())))
+		cw.Write([]byte(backend.Name()))
+	}
+	if flags&formatFlagCompact != 0 {
+		cw.writeUint64s(values)
+	}
+	cw.writeUint64(wm.size)
+	cw.writeUint64(wm.alphabetNum)
+	cw.writeUint64(wm.alphabetBitNum)
+	cw.writeUint64(uint64(len(wm.bv)))
+	for i := 0; i < len(wm.bv) && cw.err == nil; i++ {
+		buf, err := wm.bv[i].MarshalBinary()
+		if err != nil {
+			return cw.n, err
+		}
+		cw.writeUint64(uint64(len(buf)))
+		cw.Write(buf)
+	}
+	cw.writeUint64s(wm.zeros)
+	if flags&formatFlagWeights != 0 {
+		wm.writeWeightSums(cw)
+	}
+	checksum := cw.crc.Sum32()
+	cw.crc = nil
+	binary.Write(cw, binary.LittleEndian, checksum)
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+/*
+ReadFrom implements the io.ReaderFrom interface.
+It reads the binary created by WriteTo or MarshalBinary, including the legacy format.
+ReadFrom does not read beyond the end of the binary, so r should be buffered for performance.
+The structure of the binary is validated, and the error wraps ErrorInvalidFormat with the description of the broken part.
+On error, the matrix is left empty.
+*/
+func (wm *WMData) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	err := wm.readFrom(cr, nil)
+	if err != nil {
+		// Leave the empty matrix, so that queries on it fail without panic.
+		*wm = WMData{}
+	}
+	return cr.n, err
+}
+
+// readFrom reads the binary of wm. values must be given to read the binary of CompactWMData, and nil otherwise.
+func (wm *WMData) readFrom(cr *countingReader, values *[]uint64) error {
+	cr.crc = crc32.New(crc32cTable)
+	head, err := cr.readUint64()
+	if err != nil {
+		return err
+	}
+	flags := uint32(0)
+	wm.backend = defaultBitVectorBackend
+	var headBytes [sizeOfInt64]byte
+	binary.LittleEndian.PutUint64(headBytes[:], head)
+	// The legacy format has no magic bytes, and starts with the size.
+	legacy := headBytes != formatMagic
+	if legacy && values != nil {
+		return formatError("magic bytes are not found")
+	}
+	if legacy && head == formatMarker {
+		return ErrorUnsupportedVersion
+	}
+	if !legacy {
+		if v, err := cr.readUint32(); err != nil {
+			return err
+		} else if v != formatVersion {
+			return ErrorUnsupportedVersion
+		}
+		if flags, err = cr.readUint32(); err != nil {
+			return err
+		}
+		if flags&^formatFlagsKnown != 0 {
+			return ErrorUnsupportedVersion
+		}
+		if flags&formatFlagMultiary != 0 {
+			return formatError("binary is of multi-ary Wavelet-Matrix")
+		}
+		if flags&formatFlagMapped != 0 {
+			return formatError("binary is of mapped layout")
+		}
+		if flags&formatFlagBackend != 0 {
+			if err := wm.readBackend(cr); err != nil {
+				return err
+			}
+		}
+		if (flags&formatFlagCompact != 0) != (values != nil) {
+			if values == nil {
+				return formatError("binary is of compact Wavelet-Matrix")
+			}
+			return formatError("binary is not of compact Wavelet-Matrix")
+		}
+		if values != nil {
+			if *values, err = cr.readUint64s(); err != nil {
+				return err
+			}
+		}
+		if head, err = cr.readUint64(); err != nil {
+			return err
+		}
+	}
+	wm.size = head
+
+	if wm.alphabetNum, err = cr.readUint64(); err != nil {
+		return err
+	}
+	if wm.alphabetBitNum, err = cr.readUint64(); err != nil {
+		return err
+	}
+	// The ranks decoded from the levels must be less than the alphabet size.
+	if wm.alphabetBitNum != levelNumOf(wm.alphabetNum) {
+		return formatError("%d levels for alphabet size %d", wm.alphabetBitNum, wm.alphabetNum)
+	}
+	bvSize, err := cr.readUint64()
+	if err != nil {
+		return err
+	}
+	if bvSize != wm.alphabetBitNum {
+		return formatError("%d bit vectors for %d levels", bvSize, wm.alphabetBitNum)
+	}
+	wm.bv = make([]bitVector, 0, bvSize)
+	for i := uint64(0); i < bvSize; i++ {
+		vsize, err := cr.readUint64()
+		if err != nil {
+			return err
+		}
+		buf, err := cr.readBytes(vsize)
+		if err != nil {
+			return err
+		}
+		bv, err := wm.backend.UnmarshalBitVector(buf)
+		if err != nil {
+			return formatError("level %d: %v", i, err)
+		}
+		if bv.Size() != wm.size {
+			return formatError("level %d: bit vector has %d bits, expected %d", i, bv.Size(), wm.size)
+		}
+		wm.bv = append(wm.bv, bv)
+	}
+
+	wm.weightSums = nil
+	if legacy {
+		err = wm.readLegacyNodePos(cr)
+	} else if wm.zeros, err = cr.readUint64s(); err == nil && flags&formatFlagWeights != 0 {
+		err = wm.readWeightSums(cr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := wm.checkZeros(); err != nil {
+		return err
+	}
+	if legacy {
+		return nil
+	}
+
+	checksum := cr.crc.Sum32()
+	cr.crc = nil
+	expected, err := cr.readUint32()
+	if err != nil {
+		return err
+	}
+	if checksum != expected {
+		return ErrorChecksumMismatch
+	}
+	return nil
+}
+
+// checkZeros checks that the number of 0 bits of each level matches its bit vector.
+func (wm *WMData) checkZeros() error {
+	if uint64(len(wm.zeros)) != wm.alphabetBitNum {
+		return formatError("%d zero counts for %d levels", len(wm.zeros), wm.alphabetBitNum)
+	}
+	for i := uint64(0); i < wm.alphabetBitNum; i++ {
+		if zeros := wm.bv[i].NumOfBits(false); wm.zeros[i] != zeros {
+			return formatError("level %d: number of 0 bits is %d, bit vector has %d", i, wm.zeros[i], zeros)
+		}
+	}
+	return nil
+}
+
+// readBackend reads the name of the bit vector backend, and finds the backend of the name.
+func (wm *WMData) readBackend(cr *countingReader) error {
+	nameLen, err := cr.readUint64()
+	if err != nil {
+		return err
+	}
+	if nameLen > maxBackendNameLen {
+		return formatError("name of bit vector backend has %d bytes", nameLen)
+	}
+	name, err := cr.readBytes(nameLen)
+	if err != nil {
+		return err
+	}
+	backend, found := lookupBitVectorBackend(string(name))
+	if !found {
+		return formatError("bit vector backend %q is unknown", name)
+	}
+	wm.backend = backend
+	return nil
+}
+
+func (wm *WMData) writeWeightSums(cw *countingWriter) {
+	cw.writeUint64(uint64(len(wm.weightSums)))
+	for i := 0; i < len(wm.weightSums); i++ {
+		cw.writeUint64s(wm.weightSums[i])
+	}
+}
+
+func (wm *WMData) readWeightSums(cr *countingReader) error {
+	wsSize, err := cr.readUint64()
+	if err != nil {
+		return err
+	}
+	if wsSize == 0 {
+		return nil
+	}
+	if wsSize != wm.alphabetBitNum+1 {
+		return formatError("%d weight tables for %d levels", wsSize, wm.alphabetBitNum)
+	}
+	for i := uint64(0); i < wsSize; i++ {
+		sums, err := cr.readUint64s()
+		if err != nil {
+			return err
+		}
+		if uint64(len(sums)) != wm.size+1 {
+			return formatError("weight table %d has %d entries, expected %d", i, len(sums), wm.size+1)
+		}
+		wm.weightSums = append(wm.weightSums, sums)
+	}
+	return nil
+}
+
+// readLegacyNodePos reads node position tables of the legacy format and derives the zero count of each level from them.
+// The table of level i holds the beginning positions of 2^(i+1) nodes, which ascend in bit-reversed order of the index.
+func (wm *WMData) readLegacyNodePos(cr *countingReader) error {
+	npSize, err := cr.readUint64()
+	if err != nil {
+		return err
+	}
+	if npSize != wm.alphabetBitNum {
+		return formatError("%d node position tables for %d levels", npSize, wm.alphabetBitNum)
+	}
+	wm.zeros = make([]uint64, 0, npSize)
+	for i := uint64(0); i < npSize; i++ {
+		nodePos, err := cr.readUint64s()
+		if err != nil {
+			return err
+		}
+		width := i + 1
+		if width >= 64 || uint64(len(nodePos)) != uint64(1)<<width {
+			return formatError("level %d: node position table has %d entries", i, len(nodePos))
+		}
+		prev := uint64(0)
+		for j := uint64(0); j < uint64(len(nodePos)); j++ {
+			pos := nodePos[bits.Reverse64(j)>>(64-width)]
+			if pos < prev || pos > wm.size {
+				return formatError("level %d: node positions are not ascending within size", i)
+			}
+			prev = pos
+		}
+		// nodePos[i][1] is the beginning position of the elements whose bit is 1, i.e. the number of 0 bits.
+		wm.zeros = append(wm.zeros, nodePos[1])
+	}
+	sepSize, err := cr.readUint64()
+	if err != nil {
+		return err
+	}
+	if sepSize > NotFound/sizeOfInt64 {
+		return formatError("separator table has %d entries", sepSize)
+	}
+	return cr.skip(sizeOfInt64 * sepSize)
+}
+
+/*
+WriteTo implements the io.WriterTo interface.
+It writes the same binary as MarshalBinary.
+
+The binary has the same header and checksum as WMData with formatFlagCompact, and holds the distinct values before the levels.
+*/
+func (cwm *CompactWMData) WriteTo(w io.Writer) (int64, error) {
+	return cwm.wm.writeTo(w, formatFlagCompact, cwm.values)
+}
+
+/*
+ReadFrom implements the io.ReaderFrom interface.
+It reads the binary created by WriteTo or MarshalBinary.
+*/
+func (cwm *CompactWMData) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	err := cwm.readFrom(cr)
+	if err != nil {
+		*cwm = CompactWMData{wm: new(WMData)}
+	}
+	return cr.n, err
+}
+
+func (cwm *CompactWMData) readFrom(cr *countingReader) error {
+	cwm.wm = new(WMData)
+	if err := cwm.wm.readFrom(cr, &cwm.values); err != nil {
+		return err
+	}
+	for i := 1; i < len(cwm.values); i++ {
+		if cwm.values[i-1] >= cwm.values[i] {
+			return formatError("values are not strictly ascending at %d", i)
+		}
+	}
+	if cwm.wm.alphabetNum > uint64(len(cwm.values)) {
+		return formatError("alphabet size %d exceeds %d values", cwm.wm.alphabetNum, len(cwm.values))
+	}
+	return nil
+}
+
+/*
+WriteTo implements the io.WriterTo interface.
+It writes the same binary as MarshalBinary.
+
+The binary has the same header and checksum as WMData with formatFlagMultiary, and holds the words of each level.
+The rank samples and the symbol counts are rebuilt on reading.
+*/
+func (mwm *MultiaryWMData) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw, crc: crc32.New(crc32cTable)}
+	cw.Write(formatMagic[:])
+	binary.Write(cw, binary.LittleEndian, formatVersion)
+	binary.Write(cw, binary.LittleEndian, formatFlagMultiary)
+	cw.writeUint64(mwm.size)
+	cw.writeUint64(mwm.alphabetNum)
+	cw.writeUint64(mwm.alphabetBitNum)
+	cw.writeUint64(mwm.symbolBitNum)
+	cw.writeUint64(uint64(len(mwm.levels)))
+	for i := 0; i < len(mwm.levels) && cw.err == nil; i++ {
+		cw.writeUint64s(mwm.levels[i].words)
+	}
+	checksum := cw.crc.Sum32()
+	cw.crc = nil
+	binary.Write(cw, binary.LittleEndian, checksum)
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+/*
+ReadFrom implements the io.ReaderFrom interface.
+It reads the binary created by WriteTo or MarshalBinary.
+On error, the matrix is left empty.
+*/
+func (mwm *MultiaryWMData) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	err := mwm.readFrom(cr)
+	if err != nil {
+		*mwm = MultiaryWMData{}
+	}
+	return cr.n, err
+}
+
+func (mwm *MultiaryWMData) readFrom(cr *countingReader) error {
+	cr.crc = crc32.New(crc32cTable)
+	head, err := cr.readUint64()
+	if err != nil {
+		return err
+	}
+	var headBytes [sizeOfInt64]byte
+	binary.LittleEndian.PutUint64(headBytes[:], head)
+	if headBytes != formatMagic {
+		return formatError("magic bytes are not found")
+	}
+	if v, err := cr.readUint32(); err != nil {
+		return err
+	} else if v != formatVersion {
+		return ErrorUnsupportedVersion
+	}
+	flags, err := cr.readUint32()
+	if err != nil {
+		return err
+	}
+	if flags&^formatFlagsKnown != 0 {
+		return ErrorUnsupportedVersion
+	}
+	if flags != formatFlagMultiary {
+		return formatError("binary is not of multi-ary Wavelet-Matrix")
+	}
+
+	if mwm.size, err = cr.readUint64(); err != nil {
+		return err
+	}
+	if mwm.alphabetNum, err = cr.readUint64(); err != nil {
+		return err
+	}
+	if mwm.alphabetBitNum, err = cr.readUint64(); err != nil {
+		return err
+	}
+	if mwm.alphabetBitNum != levelNumOf(mwm.alphabetNum) {
+		return formatError("alphabet has %d bits for alphabet size %d", mwm.alphabetBitNum, mwm.alphabetNum)
+	}
+	if mwm.symbolBitNum, err = cr.readUint64(); err != nil {
+		return err
+	}
+	if mwm.symbolBitNum != 2 && mwm.symbolBitNum != 3 {
+		return formatError("symbol has %d bits", mwm.symbolBitNum)
+	}
+	levelNum, err := cr.readUint64()
+	if err != nil {
+		return err
+	}
+	if levelNum != mwm.levelNum() {
+		return formatError("%d levels for %d bits of alphabet", levelNum, mwm.alphabetBitNum)
+	}
+	mwm.levels = make([]multiarySequence, 0, levelNum)
+	mwm.counts = make([][]uint64, 0, levelNum)
+	for i := uint64(0); i < levelNum; i++ {
+		seq := multiarySequence{size: mwm.size, symbolBitNum: mwm.symbolBitNum, perWord: 64 / mwm.symbolBitNum}
+		if seq.words, err = cr.readUint64s(); err != nil {
+			return err
+		}
+		if uint64(len(seq.words)) != (mwm.size+seq.perWord-1)/seq.perWord {
+			return formatError("level %d: %d words for %d symbols", i, len(seq.words), mwm.size)
+		}
+		if len(seq.words) > 0 {
+			// The bits beyond the last symbol must be 0 as written by WriteTo.
+			used := (mwm.size-1)%seq.perWord*seq.symbolBitNum + seq.symbolBitNum
+			if used < 64 && seq.words[len(seq.words)-1]>>used != 0 {
+				return formatError("level %d: bits beyond the size are set", i)
+			}
+		}
+		seq.buildRanks()
+		mwm.levels = append(mwm.levels, seq)
+		mwm.counts = append(mwm.counts, seq.counts())
+	}
+
+	checksum := cr.crc.Sum32()
+	cr.crc = nil
+	expected, err := cr.readUint32()
+	if err != nil {
+		return err
+	}
+	if checksum != expected {
+		return ErrorChecksumMismatch
+	}
+	return nil
+}
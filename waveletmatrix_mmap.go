@@ -0,0 +1,431 @@
+package waveletmatrix
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"sort"
+	"unsafe"
+)
+
+// MappedWMData is Wavelet-Matrix which answers queries directly over the binary created by WriteMappedTo.
+// The binary is not copied, so that the processes which map the same file share the page cache.
+type MappedWMData struct {
+	size           uint64
+	alphabetNum    uint64
+	alphabetBitNum uint64
+	zeros          []uint64
+	bv             []mappedBitVector
+	data           []byte
+	unmap          func() error
+}
+
+// mappedBitVector is the bit vector of a level in the mapped layout.
+// ranks[j] holds the number of 1 bits in words[0 ... j*mappedBlockWords).
+type mappedBitVector struct {
+	words []uint64
+	ranks []uint64
+}
+
+const (
+	// mappedAlign is the alignment in bytes of each section of the mapped layout.
+	mappedAlign uint64 = 64
+	// mappedBlockWords is the number of words per rank sample.
+	mappedBlockWords uint64 = 8
+	mappedBlockBits  uint64 = mappedBlockWords * 64
+	// mappedHeaderWords is the number of words before the zero counts: magic, version and flags, size, alphabetNum, alphabetBitNum.
+	mappedHeaderWords uint64 = 5
+)
+
+var (
+	// ErrorMappedClosed indicates that the mapped Wavelet-Matrix has already been closed.
+	ErrorMappedClosed = errors.New("Mapped Wavelet-Matrix is already closed.")
+)
+
+// OpenMappedWM maps the file created by WriteMappedTo into memory.
+// On the platforms without mmap, the file is read into memory instead.
+// The returned matrix must be closed by Close to release the mapping.
+func OpenMappedWM(path string) (*MappedWMData, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mwm, err := NewMappedWM(data)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	mwm.unmap = unmap
+	return mwm, nil
+}
+
+// NewMappedWM returns Wavelet-Matrix over data created by WriteMappedTo. data is referred to without copying,
+// and must not be modified while the matrix is used.
+// data should be 8-byte aligned, as memory returned by mmap is. Otherwise it is copied into aligned memory.
+func NewMappedWM(data []byte) (*MappedWMData, error) {
+	dataLen := uint64(len(data))
+	if dataLen < mappedHeaderWords*sizeOfInt64 {
+		return nil, formatError("unexpected end of data")
+	}
+	if !isLittleEndian() || uintptr(unsafe.Pointer(&data[0]))%uintptr(sizeOfInt64) != 0 {
+		aligned := make([]uint64, (dataLen+sizeOfInt64-1)/sizeOfInt64)
+		copy(wordsToBytes(aligned), data)
+		if !isLittleEndian() {
+			for i := range aligned {
+				aligned[i] = binary.LittleEndian.Uint64(wordsToBytes(aligned[i : i+1]))
+			}
+		}
+		data = wordsToBytes(aligned)[:dataLen]
+	}
+
+	header := bytesToWords(data[:mappedHeaderWords*sizeOfInt64])
+	if !bytes.Equal(data[:sizeOfInt64], formatMagic[:]) {
+		return nil, formatError("magic bytes are not found")
+	}
+	if uint32(header[1]) != formatVersion {
+		return nil, ErrorUnsupportedVersion
+	}
+	if flags := uint32(header[1] >> 32); flags&^formatFlagsKnown != 0 {
+		return nil, ErrorUnsupportedVersion
+	} else if flags != formatFlagMapped {
+		return nil, formatError("binary is not of mapped layout")
+	}
+	mwm := &MappedWMData{
+		size:           header[2],
+		alphabetNum:    header[3],
+		alphabetBitNum: header[4],
+		data:           data,
+	}
+	if mwm.alphabetBitNum != levelNumOf(mwm.alphabetNum) {
+		return nil, formatError("%d levels for alphabet size %d", mwm.alphabetBitNum, mwm.alphabetNum)
+	}
+	if mwm.size > NotFound-mappedBlockBits {
+		return nil, formatError("size %d is too large", mwm.size)
+	}
+
+	offset := mappedHeaderWords * sizeOfInt64
+	zerosLen := mwm.alphabetBitNum * sizeOfInt64
+	if dataLen-offset < zerosLen {
+		return nil, formatError("unexpected end of data")
+	}
+	mwm.zeros = bytesToWords(data[offset : offset+zerosLen])
+	offset = alignUp(offset + zerosLen)
+
+	section := func(n uint64) []uint64 {
+		if offset > dataLen || (dataLen-offset)/sizeOfInt64 < n {
+			return nil
+		}
+		words := bytesToWords(data[offset : offset+n*sizeOfInt64])
+		offset = alignUp(offset + n*sizeOfInt64)
+		return words
+	}
+	wordsNum, ranksNum := mappedLevelWords(mwm.size)
+	for i := uint64(0); i < mwm.alphabetBitNum; i++ {
+		bv := mappedBitVector{words: section(wordsNum), ranks: section(ranksNum)}
+		if uint64(len(bv.words)) != wordsNum || uint64(len(bv.ranks)) != ranksNum {
+			return nil, formatError("level %d: unexpected end of data", i)
+		}
+		// Only the rank samples are checked, in order not to touch every page of the words on loading.
+		// Validate checks the words and the checksum.
+		if err := bv.checkRanks(mwm.size); err != nil {
+			return nil, formatError("level %d: %v", i, err)
+		}
+		if ones := bv.ranks[ranksNum-1]; mwm.zeros[i] != mwm.size-ones {
+			return nil, formatError("level %d: number of 0 bits is %d, rank samples have %d", i, mwm.zeros[i], mwm.size-ones)
+		}
+		mwm.bv = append(mwm.bv, bv)
+	}
+	if offset > dataLen || dataLen-offset != sizeOfInt32 {
+		return nil, formatError("checksum is not found at the end of data")
+	}
+	return mwm, nil
+}
+
+// checkRanks checks that the rank samples ascend from 0 by at most the number of bits of each block.
+func (bv *mappedBitVector) checkRanks(size uint64) error {
+	if bv.ranks[0] != 0 {
+		return fmt.Errorf("rank sample 0 is %d", bv.ranks[0])
+	}
+	for j := uint64(1); j < uint64(len(bv.ranks)); j++ {
+		blockBits := size - (j-1)*mappedBlockBits
+		if blockBits > mappedBlockBits {
+			blockBits = mappedBlockBits
+		}
+		if bv.ranks[j] < bv.ranks[j-1] || bv.ranks[j]-bv.ranks[j-1] > blockBits {
+			return fmt.Errorf("rank sample %d is %d after %d", j, bv.ranks[j], bv.ranks[j-1])
+		}
+	}
+	return nil
+}
+
+/*
+WriteMappedTo writes Wavelet-Matrix in the mapped layout, which is loaded by OpenMappedWM or NewMappedWM.
+Each section of the layout is aligned to 64 bytes, and holds little-endian 64-bit words.
+The layout starts with the same magic bytes and version as MarshalBinary with formatFlagMapped, and ends with CRC32C checksum.
+*/
+func (wm *WMData) WriteMappedTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw, crc: crc32.New(crc32cTable)}
+	cw.Write(formatMagic[:])
+	binary.Write(cw, binary.LittleEndian, formatVersion)
+	binary.Write(cw, binary.LittleEndian, formatFlagMapped)
+	cw.writeUint64(wm.size)
+	cw.writeUint64(wm.alphabetNum)
+	cw.writeUint64(wm.alphabetBitNum)
+	binary.Write(cw, binary.LittleEndian, wm.zeros)
+	writePadding(cw)
+
+	wordsNum, ranksNum := mappedLevelWords(wm.size)
+	words := make([]uint64, wordsNum)
+	ranks := make([]uint64, ranksNum)
+	for i := uint64(0); i < wm.alphabetBitNum && cw.err == nil; i++ {
+		for j := range words {
+			words[j] = 0
+		}
+		for pos := uint64(0); pos < wm.size; pos++ {
+			if b, _ := wm.bv[i].Get(pos); b {
+				words[pos/64] |= uint64(1) << (pos % 64)
+			}
+		}
+		ones := uint64(0)
+		for j := uint64(0); j < wordsNum; j++ {
+			if j%mappedBlockWords == 0 {
+				ranks[j/mappedBlockWords] = ones
+			}
+			ones += uint64(bits.OnesCount64(words[j]))
+		}
+		ranks[ranksNum-1] = ones
+		binary.Write(cw, binary.LittleEndian, words)
+		writePadding(cw)
+		binary.Write(cw, binary.LittleEndian, ranks)
+		writePadding(cw)
+	}
+	checksum := cw.crc.Sum32()
+	cw.crc = nil
+	binary.Write(cw, binary.LittleEndian, checksum)
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, nil
+}
+
+// Close releases the mapping of the file. After Close, the matrix is empty and the queries find nothing.
+func (mwm *MappedWMData) Close() error {
+	if mwm.data == nil {
+		return ErrorMappedClosed
+	}
+	mwm.data = nil
+	mwm.bv = nil
+	mwm.zeros = nil
+	mwm.size = 0
+	mwm.alphabetNum = 0
+	mwm.alphabetBitNum = 0
+	if mwm.unmap == nil {
+		return nil
+	}
+	return mwm.unmap()
+}
+
+// Size returns size of wavelet-matrix
+func (mwm *MappedWMData) Size() uint64 {
+	return mwm.size
+}
+
+// Lookup returns value of pos-th element of wavelet-matrix.
+// if pos >= (size of wavelet-matrix),  value of second result parameter is false.
+func (mwm *MappedWMData) Lookup(pos uint64) (uint64, bool) {
+	if pos >= mwm.size {
+		return NotFound, false
+	}
+	c := uint64(0)
+	for i := uint64(0); i < mwm.alphabetBitNum; i++ {
+		b := mwm.bv[i].get(pos)
+		c <<= 1
+		if b {
+			c |= 1
+		}
+		pos = mwm.nextPos(i, pos, b)
+	}
+	return c, true
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (mwm *MappedWMData) Rank(c, pos uint64) (uint64, bool) {
+	if c >= mwm.alphabetNum || pos > mwm.size {
+		return NotFound, false
+	}
+	begPos := uint64(0)
+	for i := uint64(0); i < mwm.alphabetBitNum; i++ {
+		b := mwm.bitOf(c, i)
+		begPos = mwm.nextPos(i, begPos, b)
+		pos = mwm.nextPos(i, pos, b)
+	}
+	return pos - begPos, true
+}
+
+// Freq returns the frequency of the character `c`.
+func (mwm *MappedWMData) Freq(c uint64) uint64 {
+	freq, found := mwm.Rank(c, mwm.size)
+	if !found {
+		return 0
+	}
+	return freq
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (mwm *MappedWMData) Select(c, rank uint64) (uint64, bool) {
+	if rank == 0 || rank > mwm.Freq(c) {
+		return NotFound, false
+	}
+	index := uint64(0)
+	for i := uint64(0); i < mwm.alphabetBitNum; i++ {
+		index = mwm.nextPos(i, index, mwm.bitOf(c, i))
+	}
+	return mwm.prevPos(c, index+rank-uint64(1)), true
+}
+
+// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
+func (mwm *MappedWMData) QuantileRange(begPos, endPos, k uint64) (pos, val uint64) {
+	if begPos > endPos || endPos > mwm.size || k >= endPos-begPos {
+		return NotFound, NotFound
+	}
+	for i := uint64(0); i < mwm.alphabetBitNum; i++ {
+		bv := &mwm.bv[i]
+		begZero := begPos - bv.rank1(begPos)
+		endZero := endPos - bv.rank1(endPos)
+		zeroBits := endZero - begZero
+		val <<= 1
+		if k < zeroBits {
+			begPos = begZero
+			endPos = endZero
+		} else {
+			k -= zeroBits
+			begPos = mwm.zeros[i] + begPos - begZero
+			endPos = mwm.zeros[i] + endPos - endZero
+			val |= 1
+		}
+	}
+	pos = mwm.prevPos(val, begPos+k)
+	return
+}
+
+func (mwm *MappedWMData) bitOf(c, i uint64) bool {
+	return toBool((c >> (mwm.alphabetBitNum - i - uint64(1))) & uint64(1))
+}
+
+func (mwm *MappedWMData) nextPos(i, pos uint64, b bool) uint64 {
+	ones := mwm.bv[i].rank1(pos)
+	if b {
+		return mwm.zeros[i] + ones
+	}
+	return pos - ones
+}
+
+func (mwm *MappedWMData) prevPos(c, index uint64) uint64 {
+	for i := int(mwm.alphabetBitNum) - 1; i >= 0; i-- {
+		if mwm.bitOf(c, uint64(i)) {
+			index = mwm.bv[i].select1(index - mwm.zeros[i])
+		} else {
+			index = mwm.bv[i].select0(index)
+		}
+	}
+	return index
+}
+
+func (bv *mappedBitVector) get(pos uint64) bool {
+	if pos/64 >= uint64(len(bv.words)) {
+		// The position is led outside the size by the words which disagree with the samples.
+		return false
+	}
+	return (bv.words[pos/64]>>(pos%64))&1 == 1
+}
+
+// rank1 returns the number of 1 bits in [0, pos).
+func (bv *mappedBitVector) rank1(pos uint64) uint64 {
+	block := pos / mappedBlockBits
+	ones := bv.ranks[block]
+	for j := block * mappedBlockWords; j < pos/64; j++ {
+		ones += uint64(bits.OnesCount64(bv.words[j]))
+	}
+	if r := pos % 64; r != 0 {
+		ones += uint64(bits.OnesCount64(bv.words[pos/64] & (uint64(1)<<r - 1)))
+	}
+	// The words which disagree with the samples must not lead the positions outside the size.
+	// pos at the end of the last block has no sample after it, and is counted by the samples only.
+	if block+1 < uint64(len(bv.ranks)) && ones > bv.ranks[block+1] {
+		ones = bv.ranks[block+1]
+	}
+	return ones
+}
+
+// select1 returns the position of the (rank+1)-th 1 bit.
+func (bv *mappedBitVector) select1(rank uint64) uint64 {
+	return bv.selectBit(rank, func(block uint64) uint64 { return bv.ranks[block] }, func(w uint64) uint64 { return w })
+}
+
+// select0 returns the position of the (rank+1)-th 0 bit.
+func (bv *mappedBitVector) select0(rank uint64) uint64 {
+	return bv.selectBit(rank, func(block uint64) uint64 { return block*mappedBlockBits - bv.ranks[block] }, func(w uint64) uint64 { return ^w })
+}
+
+func (bv *mappedBitVector) selectBit(rank uint64, countBefore func(block uint64) uint64, mask func(w uint64) uint64) uint64 {
+	// The last block which has at most rank bits before it contains the answer.
+	block := uint64(sort.Search(len(bv.ranks), func(j int) bool { return countBefore(uint64(j)) > rank })) - 1
+	rank -= countBefore(block)
+	for j := block * mappedBlockWords; j < uint64(len(bv.words)); j++ {
+		w := mask(bv.words[j])
+		n := uint64(bits.OnesCount64(w))
+		if rank < n {
+			for ; rank > 0; rank-- {
+				w &= w - 1
+			}
+			return j*64 + uint64(bits.TrailingZeros64(w))
+		}
+		rank -= n
+	}
+	// The words disagree with the samples.
+	return NotFound
+}
+
+// mappedLevelWords returns the number of words and rank samples of each level.
+func mappedLevelWords(size uint64) (wordsNum, ranksNum uint64) {
+	wordsNum = (size + 63) / 64
+	ranksNum = (wordsNum+mappedBlockWords-1)/mappedBlockWords + 1
+	return
+}
+
+func alignUp(offset uint64) uint64 {
+	return (offset + mappedAlign - 1) / mappedAlign * mappedAlign
+}
+
+func writePadding(cw *countingWriter) {
+	padding := alignUp(uint64(cw.n)) - uint64(cw.n)
+	cw.Write(make([]byte, padding))
+}
+
+func bytesToWords(data []byte) []uint64 {
+	if len(data) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*uint64)(unsafe.Pointer(&data[0])), uint64(len(data))/sizeOfInt64)
+}
+
+func wordsToBytes(words []uint64) []byte {
+	if len(words) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&words[0])), uint64(len(words))*sizeOfInt64)
+}
+
+func isLittleEndian() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}
@@ -0,0 +1,540 @@
+package waveletmatrix
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/hideo55/go-pq"
+)
+
+// MultiaryWMData holds information of multi-ary Wavelet-Matrix.
+// Each level holds symbols of symbolBitNum bits instead of single bits, so that the number of levels is
+// alphabetBitNum / symbolBitNum(rounded up). The first level holds the remaining upper bits if alphabetBitNum
+// is not a multiple of symbolBitNum.
+type MultiaryWMData struct {
+	size           uint64
+	alphabetNum    uint64
+	alphabetBitNum uint64
+	symbolBitNum   uint64
+	levels         []multiarySequence
+	// counts[i][s] is the number of symbols less than s in level i. In level i, the elements whose symbol is s
+	// are placed after counts[i][s] in the next level.
+	counts [][]uint64
+}
+
+// multiarySequence is the sequence of symbols of a level, which supports rank and select of every symbol.
+// The symbols are packed into words without crossing word boundaries.
+type multiarySequence struct {
+	size         uint64
+	symbolBitNum uint64
+	// perWord is the number of symbols in a word.
+	perWord uint64
+	// lowMask has the lowest bit of every symbol in a word.
+	lowMask uint64
+	// pairLowMask, pairSymbolMask and pairGuardMask have the lowest bit, the bits and the bit above the bits of
+	// every other symbol in a word, which leaves room to compare the symbols by subtraction.
+	pairLowMask    uint64
+	pairSymbolMask uint64
+	pairGuardMask  uint64
+	words          []uint64
+	// ranks[b*symbolNum+s] is the number of symbols less than s in the first b*multiaryBlockWords words.
+	ranks []uint64
+}
+
+const (
+	// multiaryBlockWords is the number of words between the rank samples of a level.
+	multiaryBlockWords = 16
+)
+
+var (
+	// ErrorSymbolBitNum indicates that the number of bits per symbol is not supported.
+	ErrorSymbolBitNum = errors.New("Number of bits per symbol must be 2 or 3.")
+)
+
+// NewMultiaryWM builds Wavelet-Matrix whose levels hold symbols of symbolBitNum bits.
+// symbolBitNum is 2 for 4-ary levels, or 3 for 8-ary levels, which halves or thirds the number of levels
+// touched by a query. The symbols are not stored in bit vectors, so that the options of NewWMWithOptions are not accepted.
+// The results are the same as WMData, except that ListModeRange may order the characters of the same frequency differently.
+func NewMultiaryWM(src []uint64, symbolBitNum uint64) (WaveletMatrix, error) {
+	if symbolBitNum != 2 && symbolBitNum != 3 {
+		return nil, ErrorSymbolBitNum
+	}
+	if len(src) == 0 {
+		return nil, ErrorEmpty
+	}
+	alphabetNum, err := getAlphabetNum(src)
+	if err != nil {
+		return nil, err
+	}
+	alphabetBitNum := levelNumOf(alphabetNum)
+	mwm := &MultiaryWMData{
+		size:           uint64(len(src)),
+		alphabetNum:    alphabetNum,
+		alphabetBitNum: alphabetBitNum,
+		symbolBitNum:   symbolBitNum,
+	}
+	levelNum := mwm.levelNum()
+	mwm.levels = make([]multiarySequence, levelNum)
+	mwm.counts = make([][]uint64, levelNum)
+
+	cur := make([]uint64, len(src))
+	copy(cur, src)
+	next := make([]uint64, len(src))
+	symbols := make([]uint64, len(src))
+	for i := uint64(0); i < levelNum; i++ {
+		for j, c := range cur {
+			symbols[j] = mwm.symbolOf(c, i)
+		}
+		mwm.levels[i] = newMultiarySequence(symbols, symbolBitNum)
+		mwm.counts[i] = mwm.levels[i].counts()
+
+		// Stable partition by the symbol.
+		pos := make([]uint64, len(mwm.counts[i]))
+		copy(pos, mwm.counts[i])
+		for j, c := range cur {
+			next[pos[symbols[j]]] = c
+			pos[symbols[j]]++
+		}
+		cur, next = next, cur
+	}
+	return mwm, nil
+}
+
+// NewMultiaryWMFromBinary restores MultiaryWMData from the binary created by MarshalBinary.
+func NewMultiaryWMFromBinary(data []byte) (WaveletMatrix, error) {
+	mwm := new(MultiaryWMData)
+	err := mwm.UnmarshalBinary(data)
+	return mwm, err
+}
+
+func newMultiarySequence(symbols []uint64, symbolBitNum uint64) multiarySequence {
+	seq := multiarySequence{size: uint64(len(symbols)), symbolBitNum: symbolBitNum, perWord: 64 / symbolBitNum}
+	seq.words = make([]uint64, (seq.size+seq.perWord-1)/seq.perWord)
+	for j, s := range symbols {
+		seq.words[uint64(j)/seq.perWord] |= s << (uint64(j) % seq.perWord * symbolBitNum)
+	}
+	seq.buildRanks()
+	return seq
+}
+
+// buildRanks builds the masks and the rank samples from the words.
+func (seq *multiarySequence) buildRanks() {
+	seq.perWord = 64 / seq.symbolBitNum
+	seq.lowMask = 0
+	for f := uint64(0); f < seq.perWord; f++ {
+		seq.lowMask |= 1 << (f * seq.symbolBitNum)
+	}
+	seq.pairLowMask, seq.pairSymbolMask, seq.pairGuardMask = 0, 0, 0
+	for f := uint64(0); f < 64; f += 2 * seq.symbolBitNum {
+		seq.pairLowMask |= 1 << f
+		seq.pairSymbolMask |= (uint64(1)<<seq.symbolBitNum - 1) << f
+		seq.pairGuardMask |= 1 << (f + seq.symbolBitNum)
+	}
+	symbolNum := uint64(1) << seq.symbolBitNum
+	blockLen := multiaryBlockWords * seq.perWord
+	sampleNum := uint64(len(seq.words))/multiaryBlockWords + 1
+	seq.ranks = make([]uint64, 0, sampleNum*symbolNum)
+	counts := make([]uint64, symbolNum)
+	appendSample := func() {
+		less := uint64(0)
+		for s := uint64(0); s < symbolNum; s++ {
+			seq.ranks = append(seq.ranks, less)
+			less += counts[s]
+		}
+	}
+	for j := uint64(0); j < seq.size; j++ {
+		if j%blockLen == 0 {
+			appendSample()
+		}
+		counts[seq.get(j)]++
+	}
+	for uint64(len(seq.ranks)) < sampleNum*symbolNum {
+		appendSample()
+	}
+}
+
+// counts returns the number of symbols less than s for every symbol s.
+func (seq *multiarySequence) counts() []uint64 {
+	symbolNum := uint64(1) << seq.symbolBitNum
+	counts := make([]uint64, symbolNum)
+	for s := uint64(1); s < symbolNum; s++ {
+		counts[s] = seq.rankLess(s, seq.size)
+	}
+	return counts
+}
+
+func (seq *multiarySequence) get(i uint64) uint64 {
+	return (seq.words[i/seq.perWord] >> (i % seq.perWord * seq.symbolBitNum)) & (uint64(1)<<seq.symbolBitNum - 1)
+}
+
+// matches returns the word which has the lowest bit of every symbol equal to s in word.
+func (seq *multiarySequence) matches(word, s uint64) uint64 {
+	x := word ^ (s * seq.lowMask)
+	t := x
+	for j := uint64(1); j < seq.symbolBitNum; j++ {
+		t |= x >> j
+	}
+	return ^t & seq.lowMask
+}
+
+// lessCount returns the number of symbols less than s in the first n symbols of word.
+// The symbols of even and odd indexes are compared separately, so that each symbol has the guard bit above it,
+// which is cleared by subtracting s if the symbol is less than s.
+func (seq *multiarySequence) lessCount(word, s, n uint64) uint64 {
+	count := uint64(0)
+	for odd := uint64(0); odd < 2; odd++ {
+		x := (word>>(odd*seq.symbolBitNum))&seq.pairSymbolMask | seq.pairGuardMask
+		less := ^(x - s*seq.pairLowMask) & seq.pairGuardMask
+		if m := (n + 1 - odd) / 2 * 2 * seq.symbolBitNum; m < 64 {
+			less &= uint64(1)<<m - 1
+		}
+		count += uint64(bits.OnesCount64(less))
+	}
+	return count
+}
+
+// sampleRank returns the number of s in the first block*multiaryBlockWords words.
+func (seq *multiarySequence) sampleRank(block, s uint64) uint64 {
+	i := block<<seq.symbolBitNum + s
+	if s+1 < uint64(1)<<seq.symbolBitNum {
+		return seq.ranks[i+1] - seq.ranks[i]
+	}
+	n := block * multiaryBlockWords * seq.perWord
+	if n > seq.size {
+		n = seq.size
+	}
+	return n - seq.ranks[i]
+}
+
+// rank returns the number of s in [0, pos).
+func (seq *multiarySequence) rank(s, pos uint64) uint64 {
+	w := pos / seq.perWord
+	block := w / multiaryBlockWords
+	rank := seq.sampleRank(block, s)
+	for j := block * multiaryBlockWords; j < w; j++ {
+		rank += uint64(bits.OnesCount64(seq.matches(seq.words[j], s)))
+	}
+	if r := pos % seq.perWord; r != 0 {
+		rank += uint64(bits.OnesCount64(seq.matches(seq.words[w], s) & (uint64(1)<<(r*seq.symbolBitNum) - 1)))
+	}
+	return rank
+}
+
+// rankLess returns the number of symbols less than s in [0, pos).
+func (seq *multiarySequence) rankLess(s, pos uint64) uint64 {
+	w := pos / seq.perWord
+	block := w / multiaryBlockWords
+	rank := seq.ranks[block<<seq.symbolBitNum+s]
+	for j := block * multiaryBlockWords; j < w; j++ {
+		rank += seq.lessCount(seq.words[j], s, seq.perWord)
+	}
+	if r := pos % seq.perWord; r != 0 {
+		rank += seq.lessCount(seq.words[w], s, r)
+	}
+	return rank
+}
+
+// selectSymbol returns the position of the (x+1)-th s.
+func (seq *multiarySequence) selectSymbol(s, x uint64) (uint64, bool) {
+	sampleNum := len(seq.ranks) >> seq.symbolBitNum
+	block := uint64(sort.Search(sampleNum, func(b int) bool { return seq.sampleRank(uint64(b), s) > x }) - 1)
+	x -= seq.sampleRank(block, s)
+	for j := block * multiaryBlockWords; j < uint64(len(seq.words)); j++ {
+		m := seq.matches(seq.words[j], s)
+		if cnt := uint64(bits.OnesCount64(m)); x >= cnt {
+			x -= cnt
+			continue
+		}
+		for ; x > 0; x-- {
+			m &= m - 1
+		}
+		pos := j*seq.perWord + uint64(bits.TrailingZeros64(m))/seq.symbolBitNum
+		if pos >= seq.size {
+			break
+		}
+		return pos, true
+	}
+	return NotFound, false
+}
+
+// levelNum returns the number of levels.
+func (mwm *MultiaryWMData) levelNum() uint64 {
+	return (mwm.alphabetBitNum + mwm.symbolBitNum - 1) / mwm.symbolBitNum
+}
+
+// shiftOf returns the position of the lowest bit of the symbol of level i in a character.
+func (mwm *MultiaryWMData) shiftOf(i uint64) uint64 {
+	return mwm.symbolBitNum * (uint64(len(mwm.levels)) - i - 1)
+}
+
+// symbolOf returns the symbol of `c` which is used in level i.
+func (mwm *MultiaryWMData) symbolOf(c, i uint64) uint64 {
+	return (c >> mwm.shiftOf(i)) & (uint64(1)<<mwm.symbolBitNum - 1)
+}
+
+// Size returns size of wavelet-matrix
+func (mwm *MultiaryWMData) Size() uint64 {
+	return mwm.size
+}
+
+// Checked returns the view of mwm whose API reports invalid arguments by errors.
+func (mwm *MultiaryWMData) Checked() CheckedWaveletMatrix {
+	return checkedMatrix{mwm}
+}
+
+func (mwm *MultiaryWMData) alphabetSize() uint64 {
+	return mwm.alphabetNum
+}
+
+// Lookup element by pos.
+// This function returns value of pos-th element of wavelet-matrix.
+// if pos >= (size of wavelet-matrix),  value of second result parameter is false.
+func (mwm *MultiaryWMData) Lookup(pos uint64) (uint64, bool) {
+	return legacyMatrix{mwm.Checked()}.Lookup(pos)
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (mwm *MultiaryWMData) Rank(c, pos uint64) (uint64, bool) {
+	return legacyMatrix{mwm.Checked()}.Rank(c, pos)
+}
+
+// RankAll returns the frequency of characters c' < c, c'=c, and c' > c, in the subarray A[begPos...endPos)
+func (mwm *MultiaryWMData) RankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
+	return legacyMatrix{mwm.Checked()}.RankAll(c, beginPos, endPos)
+}
+
+// RankLessThan returns the frequency of characters c' < c in the subarray A[0...pos)
+func (mwm *MultiaryWMData) RankLessThan(c, pos uint64) uint64 {
+	return legacyMatrix{mwm.Checked()}.RankLessThan(c, pos)
+}
+
+// RankMoreThan returns the frequency of characters c' > c in the subarray A[0...pos)
+func (mwm *MultiaryWMData) RankMoreThan(c, pos uint64) uint64 {
+	return legacyMatrix{mwm.Checked()}.RankMoreThan(c, pos)
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (mwm *MultiaryWMData) Select(c, rank uint64) (uint64, bool) {
+	return legacyMatrix{mwm.Checked()}.Select(c, rank)
+}
+
+// SelectFromPos returns the position of the rank-th occurrence of `c` in the suffix of the array starting from 'pos'
+func (mwm *MultiaryWMData) SelectFromPos(c, pos, rank uint64) (uint64, bool) {
+	return legacyMatrix{mwm.Checked()}.SelectFromPos(c, pos, rank)
+}
+
+// Freq returns the frequency of the character `c`.
+func (mwm *MultiaryWMData) Freq(c uint64) uint64 {
+	return legacyMatrix{mwm.Checked()}.Freq(c)
+}
+
+// FreqSum returns frequency of the characters(minC <= c' < maxC)
+func (mwm *MultiaryWMData) FreqSum(minC, maxC uint64) uint64 {
+	return legacyMatrix{mwm.Checked()}.FreqSum(minC, maxC)
+}
+
+// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
+func (mwm *MultiaryWMData) FreqRange(minC, maxC, begPos, endPos uint64) uint64 {
+	return legacyMatrix{mwm.Checked()}.FreqRange(minC, maxC, begPos, endPos)
+}
+
+// QuantileRange returns the K-th smallest value( and position) in the subarray A[begPos ... endPos)
+func (mwm *MultiaryWMData) QuantileRange(begPos, endPos, k uint64) (pos, val uint64) {
+	return legacyMatrix{mwm.Checked()}.QuantileRange(begPos, endPos, k)
+}
+
+// MaxRange returns maximum value(and position) in the subarray A[begPos .. endPos]
+func (mwm *MultiaryWMData) MaxRange(begPos, endPos uint64) (pos, val uint64) {
+	return legacyMatrix{mwm.Checked()}.MaxRange(begPos, endPos)
+}
+
+// MinRange returns minimum value(and position) in the subarray A[begPos .. endPos]
+func (mwm *MultiaryWMData) MinRange(begPos, endPos uint64) (pos, val uint64) {
+	return legacyMatrix{mwm.Checked()}.MinRange(begPos, endPos)
+}
+
+// NextValue returns the smallest value c >= x(and the position of its first occurrence) in the subarray A[begPos ... endPos)
+func (mwm *MultiaryWMData) NextValue(begPos, endPos, x uint64) (pos, val uint64) {
+	return legacyMatrix{mwm.Checked()}.NextValue(begPos, endPos, x)
+}
+
+// PrevValue returns the largest value c < x(and the position of its last occurrence) in the subarray A[begPos ... endPos)
+func (mwm *MultiaryWMData) PrevValue(begPos, endPos, x uint64) (pos, val uint64) {
+	return legacyMatrix{mwm.Checked()}.PrevValue(begPos, endPos, x)
+}
+
+// ListModeRange returns list of the distinct characters appeared in A[begPos ... endPos) from most frequent ones.
+func (mwm *MultiaryWMData) ListModeRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{mwm.Checked()}.ListModeRange(minC, maxC, begPos, endPos, num)
+}
+
+// ListMinRange returns list of the distinct characters in A[begPos ... endPos) minC <= c < maxC  from smallest ones.
+func (mwm *MultiaryWMData) ListMinRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{mwm.Checked()}.ListMinRange(minC, maxC, begPos, endPos, num)
+}
+
+// ListMaxRange returns list of the distinct characters appeared in A[begPos ... endPos) from largest ones.
+func (mwm *MultiaryWMData) ListMaxRange(minC, maxC, begPos, endPos, num uint64) []ListResult {
+	return legacyMatrix{mwm.Checked()}.ListMaxRange(minC, maxC, begPos, endPos, num)
+}
+
+/*
+MarshalBinary implements the encoding.BinaryMarshaler interface.
+*/
+func (mwm *MultiaryWMData) MarshalBinary() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	if _, err := mwm.WriteTo(buffer); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+/*
+UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+*/
+func (mwm *MultiaryWMData) UnmarshalBinary(data []byte) error {
+	_, err := mwm.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// lookup returns value of pos-th element without argument checks.
+func (mwm *MultiaryWMData) lookup(pos uint64) uint64 {
+	c := uint64(0)
+	for i := range mwm.levels {
+		s := mwm.levels[i].get(pos)
+		c = c<<mwm.symbolBitNum | s
+		pos = mwm.nextPos(uint64(i), pos, s)
+	}
+	return c
+}
+
+// rank returns the frequency of `c` in A[begPos...endPos) without argument checks.
+func (mwm *MultiaryWMData) rank(c, beginPos, endPos uint64) uint64 {
+	for i := uint64(0); i < uint64(len(mwm.levels)); i++ {
+		s := mwm.symbolOf(c, i)
+		beginPos = mwm.nextPos(i, beginPos, s)
+		endPos = mwm.nextPos(i, endPos, s)
+	}
+	return endPos - beginPos
+}
+
+// rankAll returns the frequency of characters c' < c, c'=c, and c' > c, in A[begPos...endPos) without argument checks.
+// c must be less than 2^alphabetBitNum.
+func (mwm *MultiaryWMData) rankAll(c, beginPos, endPos uint64) (rank, rankLessThan, rankMoreThan uint64) {
+	rank, rankLessThan, rankMoreThan = uint64(0), uint64(0), uint64(0)
+
+	for i := uint64(0); i < uint64(len(mwm.levels)) && beginPos < endPos; i++ {
+		seq := &mwm.levels[i]
+		s := mwm.symbolOf(c, i)
+		less := seq.rankLess(s, endPos) - seq.rankLess(s, beginPos)
+		nextBegin := mwm.nextPos(i, beginPos, s)
+		nextEnd := mwm.nextPos(i, endPos, s)
+		rankLessThan += less
+		rankMoreThan += (endPos - beginPos) - less - (nextEnd - nextBegin)
+		beginPos, endPos = nextBegin, nextEnd
+	}
+	if beginPos < endPos {
+		rank = endPos - beginPos
+	}
+	return
+}
+
+// rankLessThan returns the frequency of characters c' < c in the subarray A[begPos...endPos). c may be any value.
+func (mwm *MultiaryWMData) rankLessThan(c, begPos, endPos uint64) uint64 {
+	if c >= mwm.alphabetNum {
+		return endPos - begPos
+	}
+	_, rank, _ := mwm.rankAll(c, begPos, endPos)
+	return rank
+}
+
+// selectFromPos returns the position of the rank-th occurrence of `c` in A[pos...size).
+// If `c` does not occur rank times, the second result is false.
+func (mwm *MultiaryWMData) selectFromPos(c, pos, rank uint64) (uint64, bool) {
+	index := pos
+	endPos := mwm.size
+	for i := uint64(0); i < uint64(len(mwm.levels)); i++ {
+		s := mwm.symbolOf(c, i)
+		index = mwm.nextPos(i, index, s)
+		endPos = mwm.nextPos(i, endPos, s)
+	}
+	if endPos-index < rank {
+		return NotFound, false
+	}
+	return mwm.prevPos(c, index+rank-uint64(1))
+}
+
+// nextPos maps pos in level i to the position in level i+1.
+func (mwm *MultiaryWMData) nextPos(i, pos, s uint64) uint64 {
+	return mwm.counts[i][s] + mwm.levels[i].rank(s, pos)
+}
+
+// prevPos maps index in the bottom level, where the element is `c`, to the position in the array.
+func (mwm *MultiaryWMData) prevPos(c, index uint64) (uint64, bool) {
+	for i := len(mwm.levels) - 1; i >= 0; i-- {
+		s := mwm.symbolOf(c, uint64(i))
+		var found bool
+		if index, found = mwm.levels[i].selectSymbol(s, index-mwm.counts[i][s]); !found {
+			return NotFound, false
+		}
+	}
+	return index, true
+}
+
+// quantileRange returns the K-th smallest value( and position) in A[begPos ... endPos) without argument checks.
+func (mwm *MultiaryWMData) quantileRange(begPos, endPos, k uint64) (pos, val uint64) {
+	val = 0
+	symbolNum := uint64(1) << mwm.symbolBitNum
+	for i := uint64(0); i < uint64(len(mwm.levels)); i++ {
+		// s is the largest symbol which has at most k symbols less than it in the range.
+		seq := &mwm.levels[i]
+		s, less := uint64(0), uint64(0)
+		for hi := symbolNum; hi-s > 1; {
+			mid := (s + hi) / 2
+			if cnt := seq.rankLess(mid, endPos) - seq.rankLess(mid, begPos); cnt <= k {
+				s, less = mid, cnt
+			} else {
+				hi = mid
+			}
+		}
+		k -= less
+		begPos = mwm.nextPos(i, begPos, s)
+		endPos = mwm.nextPos(i, endPos, s)
+		val = val<<mwm.symbolBitNum | s
+	}
+	pos, _ = mwm.prevPos(val, begPos+k)
+	return
+}
+
+// listRange lists the distinct characters minC <= c < maxC in A[begPos ... endPos) in the order of comparator without argument checks.
+func (mwm *MultiaryWMData) listRange(minC, maxC, begPos, endPos, num uint64, comparator pq.CmpFunc) []ListResult {
+	var res []ListResult
+	if begPos >= endPos || minC >= maxC {
+		return res
+	}
+
+	q := pq.NewPriorityQueue(comparator)
+	q.Push(&queryOnNode{begPos, endPos, 0, 0})
+	for uint64(len(res)) < num && !q.Empty() {
+		qon := q.Pop().(*queryOnNode)
+		if qon.depth >= uint64(len(mwm.levels)) {
+			res = append(res, ListResult{qon.prefixChar, qon.endPos - qon.begPos})
+			continue
+		}
+		// Every child of a node is a range of the next level, which holds the elements of the symbol.
+		symbolNum := uint64(1) << mwm.symbolBitNum
+		shift := mwm.shiftOf(qon.depth)
+		for s := uint64(0); s < symbolNum; s++ {
+			nextBeg := mwm.nextPos(qon.depth, qon.begPos, s)
+			nextEnd := mwm.nextPos(qon.depth, qon.endPos, s)
+			nextPrefix := qon.prefixChar<<mwm.symbolBitNum | s
+			if nextBeg < nextEnd && minC>>shift <= nextPrefix && nextPrefix <= (maxC-uint64(1))>>shift {
+				q.Push(&queryOnNode{nextBeg, nextEnd, qon.depth + 1, nextPrefix})
+			}
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,116 @@
+package waveletmatrix
+
+import (
+	"sync"
+)
+
+// buildLevelsParallel builds the levels of builder.wm from cur using builder.workers goroutines.
+// In each level, the array is split into chunks, and each goroutine counts 0 bits of its chunk.
+// After the offsets of each chunk are derived from the counts, each goroutine partitions its chunk
+// into the array of the next level and fills the bits of the level.
+// The bit vector of the level is built in the background while the next level is processed,
+// so that the builders of the backend are used concurrently.
+// The content of cur is destroyed.
+func (builder *wmBuilderData) buildLevelsParallel(cur []uint64) error {
+	wm := builder.wm
+	size := wm.size
+	workers := uint64(builder.workers)
+
+	// Chunks are aligned to 64 elements so that each goroutine fills its own words.
+	chunkSize := (size + workers - 1) / workers
+	chunkSize = (chunkSize + 63) &^ 63
+	chunkNum := (size + chunkSize - 1) / chunkSize
+	chunkZeros := make([]uint64, chunkNum)
+	zeroOffsets := make([]uint64, chunkNum)
+	oneOffsets := make([]uint64, chunkNum)
+
+	next := make([]uint64, size)
+	bvErrors := make([]error, wm.alphabetBitNum)
+	var bvWait sync.WaitGroup
+	for i := uint64(0); i < wm.alphabetBitNum; i++ {
+		shift := wm.alphabetBitNum - i - 1
+
+		forEachChunk(chunkNum, func(w uint64) {
+			beg, end := chunkRange(w, chunkSize, size)
+			zeros := uint64(0)
+			for j := beg; j < end; j++ {
+				if (cur[j]>>shift)&1 == 0 {
+					zeros++
+				}
+			}
+			chunkZeros[w] = zeros
+		})
+
+		zeros := uint64(0)
+		for w := uint64(0); w < chunkNum; w++ {
+			zeroOffsets[w] = zeros
+			zeros += chunkZeros[w]
+		}
+		wm.zeros[i] = zeros
+		ones := zeros
+		for w := uint64(0); w < chunkNum; w++ {
+			oneOffsets[w] = ones
+			beg, end := chunkRange(w, chunkSize, size)
+			ones += end - beg - chunkZeros[w]
+		}
+
+		words := make([]uint64, (size+63)/64)
+		forEachChunk(chunkNum, func(w uint64) {
+			beg, end := chunkRange(w, chunkSize, size)
+			zeroPos := zeroOffsets[w]
+			onePos := oneOffsets[w]
+			for j := beg; j < end; j++ {
+				bit := (cur[j] >> shift) & 1
+				words[j/64] |= bit << (j % 64)
+				if bit == 0 {
+					next[zeroPos] = cur[j]
+					zeroPos++
+				} else {
+					next[onePos] = cur[j]
+					onePos++
+				}
+			}
+		})
+
+		bvWait.Add(1)
+		go func(i uint64, words []uint64) {
+			defer bvWait.Done()
+			bvBuilder := builder.backend.NewBuilder(builder.selectIndex)
+			for j := uint64(0); j < size; j++ {
+				bvBuilder.Set(j, toBool((words[j/64]>>(j%64))&1))
+			}
+			wm.bv[i], bvErrors[i] = bvBuilder.Build()
+		}(i, words)
+
+		cur, next = next, cur
+	}
+	bvWait.Wait()
+	for _, err := range bvErrors {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forEachChunk calls f for each chunk concurrently and waits for all of them.
+func forEachChunk(chunkNum uint64, f func(w uint64)) {
+	var wait sync.WaitGroup
+	wait.Add(int(chunkNum))
+	for w := uint64(0); w < chunkNum; w++ {
+		go func(w uint64) {
+			defer wait.Done()
+			f(w)
+		}(w)
+	}
+	wait.Wait()
+}
+
+func chunkRange(w, chunkSize, size uint64) (beg, end uint64) {
+	beg = w * chunkSize
+	end = beg + chunkSize
+	if end > size {
+		end = size
+	}
+	return
+}
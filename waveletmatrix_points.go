@@ -0,0 +1,142 @@
+package waveletmatrix
+
+import (
+	"sort"
+
+	"github.com/hideo55/go-pq"
+)
+
+// Point is a point on the two-dimensional grid.
+type Point struct {
+	X uint64
+	Y uint64
+}
+
+// PointsData holds points for orthogonal range queries.
+// The points are sorted by X, and Wavelet-Matrix is built over their Y in that order,
+// so that a range of X corresponds to a range of positions.
+type PointsData struct {
+	xs []uint64
+	wm *WMData
+}
+
+// PointIterator iterates the points reported by ReportRect.
+type PointIterator struct {
+	points *PointsData
+	minY   uint64
+	maxY   uint64
+	stack  []*queryOnNode
+	leaf   *queryOnNode
+}
+
+// NewPoints builds PointsData from points. X may be sparse.
+func NewPoints(points []Point) (*PointsData, error) {
+	if len(points) == 0 {
+		return nil, ErrorEmpty
+	}
+	sorted := make([]Point, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].X < sorted[j].X })
+
+	p := &PointsData{xs: make([]uint64, len(sorted))}
+	ys := make([]uint64, len(sorted))
+	for i := 0; i < len(sorted); i++ {
+		p.xs[i] = sorted[i].X
+		ys[i] = sorted[i].Y
+	}
+	builder := newWMBuilder(nil)
+	wm, err := builder.build(ys)
+	if err != nil {
+		return nil, err
+	}
+	p.wm = wm.(*WMData)
+	return p, nil
+}
+
+// Size returns the number of points.
+func (p *PointsData) Size() uint64 {
+	return p.wm.size
+}
+
+// posRange maps the range of X [minX, maxX) to the range of positions.
+func (p *PointsData) posRange(minX, maxX uint64) (begPos, endPos uint64) {
+	begPos = uint64(sort.Search(len(p.xs), func(i int) bool { return p.xs[i] >= minX }))
+	endPos = uint64(sort.Search(len(p.xs), func(i int) bool { return p.xs[i] >= maxX }))
+	if endPos < begPos {
+		endPos = begPos
+	}
+	return
+}
+
+// CountRect returns the number of points in the rectangle [minX, maxX) x [minY, maxY).
+func (p *PointsData) CountRect(minX, maxX, minY, maxY uint64) uint64 {
+	if minY >= maxY {
+		return 0
+	}
+	begPos, endPos := p.posRange(minX, maxX)
+	return p.wm.rankLessThan(maxY, begPos, endPos) - p.wm.rankLessThan(minY, begPos, endPos)
+}
+
+// ReportRect returns the iterator of the points in the rectangle [minX, maxX) x [minY, maxY).
+// The points are reported in ascending order of Y, and points with the same Y in ascending order of X.
+func (p *PointsData) ReportRect(minX, maxX, minY, maxY uint64) *PointIterator {
+	it := &PointIterator{points: p, minY: minY, maxY: maxY}
+	begPos, endPos := p.posRange(minX, maxX)
+	if begPos < endPos && minY < maxY {
+		it.stack = append(it.stack, &queryOnNode{begPos, endPos, 0, 0})
+	}
+	return it
+}
+
+// Next returns the next point. If there are no more points, the second result is false.
+func (it *PointIterator) Next() (Point, bool) {
+	wm := it.points.wm
+	for it.leaf == nil || it.leaf.begPos >= it.leaf.endPos {
+		if len(it.stack) == 0 {
+			return Point{}, false
+		}
+		qon := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+		if qon.depth >= wm.alphabetBitNum {
+			it.leaf = qon
+			continue
+		}
+		next := wm.expandNode(it.minY, it.maxY, qon)
+		// Push in reverse order so that the node with smaller prefix is popped first.
+		for i := len(next) - 1; i >= 0; i-- {
+			it.stack = append(it.stack, next[i])
+		}
+	}
+	pos, _ := wm.prevPos(it.leaf.prefixChar, it.leaf.begPos)
+	it.leaf.begPos++
+	return Point{it.points.xs[pos], it.leaf.prefixChar}, true
+}
+
+// TopKRect returns at most k points in the rectangle [minX, maxX) x [minY, maxY) from the largest Y.
+// Points with the same Y are returned in ascending order of X.
+func (p *PointsData) TopKRect(minX, maxX, minY, maxY, k uint64) []Point {
+	var res []Point
+	begPos, endPos := p.posRange(minX, maxX)
+	if begPos >= endPos || minY >= maxY {
+		return res
+	}
+
+	wm := p.wm
+	q := pq.NewPriorityQueue(maxComparator)
+	q.Push(&queryOnNode{begPos, endPos, 0, 0})
+	for uint64(len(res)) < k && !q.Empty() {
+		qon := q.Pop().(*queryOnNode)
+		if qon.depth >= wm.alphabetBitNum {
+			for index := qon.begPos; index < qon.endPos && uint64(len(res)) < k; index++ {
+				pos, _ := wm.prevPos(qon.prefixChar, index)
+				res = append(res, Point{p.xs[pos], qon.prefixChar})
+			}
+		} else {
+			next := wm.expandNode(minY, maxY, qon)
+			for _, n := range next {
+				q.Push(n)
+			}
+		}
+	}
+	return res
+}
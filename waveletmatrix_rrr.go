@@ -0,0 +1,341 @@
+package waveletmatrix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"sort"
+)
+
+// rrrBitVector is the bit vector compressed by RRR(Raman, Raman and Rao) encoding.
+// The bits are split into blocks of rrrBlockBits bits. Each block is encoded as its class(the number of 1 bits)
+// and its offset(the index among the blocks of the same class), whose width depends on the class.
+// Skewed bit vectors, whose blocks are mostly of class 0 or rrrBlockBits, are compressed toward nH0 bits.
+type rrrBitVector struct {
+	size uint64
+	ones uint64
+	// classes holds the class of each block in 4 bits.
+	classes []uint64
+	// offsets holds the offset of each block in rrrOffsetWidth[class] bits.
+	offsets    []uint64
+	offsetBits uint64
+	// superRanks and superOffsets hold the number of 1 bits and the position in offsets
+	// before each superblock of rrrSuperBlocks blocks.
+	superRanks   []uint64
+	superOffsets []uint64
+}
+
+type rrrBackend struct{}
+
+// rrrBuilder holds the plain bits until Build.
+type rrrBuilder struct {
+	words []uint64
+	size  uint64
+}
+
+const (
+	rrrBlockBits   uint64 = 15
+	rrrClassBits   uint64 = 4
+	rrrSuperBlocks uint64 = 32
+)
+
+var (
+	// rrrBitVectorBackend is the backend of RRR-compressed bit vectors, which is selected by RRRBitVectorBackend.
+	rrrBitVectorBackend bitVectorBackend = rrrBackend{}
+
+	// rrrBinomial[n][k] is the binomial coefficient C(n, k).
+	rrrBinomial [rrrBlockBits + 1][rrrBlockBits + 1]uint64
+	// rrrOffsetWidth[k] is the number of bits to encode the offset of the block of class k.
+	rrrOffsetWidth [rrrBlockBits + 1]uint64
+)
+
+func init() {
+	for n := uint64(0); n <= rrrBlockBits; n++ {
+		rrrBinomial[n][0] = 1
+		for k := uint64(1); k <= n; k++ {
+			rrrBinomial[n][k] = rrrBinomial[n-1][k-1] + rrrBinomial[n-1][k]
+		}
+	}
+	for k := uint64(0); k <= rrrBlockBits; k++ {
+		rrrOffsetWidth[k] = uint64(bits.Len64(rrrBinomial[rrrBlockBits][k] - 1))
+	}
+}
+
+func (rrrBackend) Name() string {
+	return "rrr"
+}
+
+// NewBuilder returns the builder of RRR-compressed bit vector. selectIndex is ignored,
+// because Select uses the rank samples of superblocks.
+func (rrrBackend) NewBuilder(selectIndex bool) bitVectorBuilder {
+	return &rrrBuilder{}
+}
+
+func (rrrBackend) UnmarshalBitVector(data []byte) (bitVector, error) {
+	bv := &rrrBitVector{}
+	if err := bv.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return bv, nil
+}
+
+func (b *rrrBuilder) Set(i uint64, bit bool) {
+	for uint64(len(b.words)) <= i/64 {
+		b.words = append(b.words, 0)
+	}
+	if bit {
+		b.words[i/64] |= uint64(1) << (i % 64)
+	} else {
+		b.words[i/64] &^= uint64(1) << (i % 64)
+	}
+	if i >= b.size {
+		b.size = i + 1
+	}
+}
+
+func (b *rrrBuilder) Build() (bitVector, error) {
+	bv := &rrrBitVector{size: b.size}
+	blockNum := (b.size + rrrBlockBits - 1) / rrrBlockBits
+	bv.classes = make([]uint64, (blockNum*rrrClassBits+63)/64)
+	for blk := uint64(0); blk < blockNum; blk++ {
+		block := getBits(b.words, blk*rrrBlockBits, rrrBlockBits)
+		class := uint64(bits.OnesCount64(block))
+		bv.classes[blk*rrrClassBits/64] |= class << (blk * rrrClassBits % 64)
+		bv.offsets = putBits(bv.offsets, bv.offsetBits, rrrEncode(block, class), rrrOffsetWidth[class])
+		bv.offsetBits += rrrOffsetWidth[class]
+	}
+	bv.buildSuperBlocks()
+	return bv, nil
+}
+
+// buildSuperBlocks derives the samples of superblocks from the classes.
+func (bv *rrrBitVector) buildSuperBlocks() {
+	blockNum := bv.blockNum()
+	superNum := (blockNum + rrrSuperBlocks - 1) / rrrSuperBlocks
+	bv.superRanks = make([]uint64, superNum)
+	bv.superOffsets = make([]uint64, superNum)
+	ones := uint64(0)
+	offset := uint64(0)
+	for blk := uint64(0); blk < blockNum; blk++ {
+		if blk%rrrSuperBlocks == 0 {
+			bv.superRanks[blk/rrrSuperBlocks] = ones
+			bv.superOffsets[blk/rrrSuperBlocks] = offset
+		}
+		class := bv.class(blk)
+		ones += class
+		offset += rrrOffsetWidth[class]
+	}
+	bv.ones = ones
+}
+
+func (bv *rrrBitVector) blockNum() uint64 {
+	return (bv.size + rrrBlockBits - 1) / rrrBlockBits
+}
+
+func (bv *rrrBitVector) class(blk uint64) uint64 {
+	return (bv.classes[blk*rrrClassBits/64] >> (blk * rrrClassBits % 64)) & (uint64(1)<<rrrClassBits - 1)
+}
+
+// block returns the bits of the block blk, and the number of 1 bits before it.
+func (bv *rrrBitVector) block(blk uint64) (block, ones uint64) {
+	super := blk / rrrSuperBlocks
+	ones = bv.superRanks[super]
+	offset := bv.superOffsets[super]
+	for b := super * rrrSuperBlocks; b < blk; b++ {
+		class := bv.class(b)
+		ones += class
+		offset += rrrOffsetWidth[class]
+	}
+	class := bv.class(blk)
+	return rrrDecode(getBits(bv.offsets, offset, rrrOffsetWidth[class]), class), ones
+}
+
+func (bv *rrrBitVector) Size() uint64 {
+	return bv.size
+}
+
+func (bv *rrrBitVector) NumOfBits(b bool) uint64 {
+	if b {
+		return bv.ones
+	}
+	return bv.size - bv.ones
+}
+
+func (bv *rrrBitVector) Get(i uint64) (bool, error) {
+	if i >= bv.size {
+		return false, ErrOutOfRange
+	}
+	block, _ := bv.block(i / rrrBlockBits)
+	return (block>>(i%rrrBlockBits))&1 == 1, nil
+}
+
+func (bv *rrrBitVector) Rank1(i uint64) (uint64, error) {
+	if i > bv.size {
+		return 0, ErrOutOfRange
+	}
+	if i == bv.size {
+		return bv.ones, nil
+	}
+	block, ones := bv.block(i / rrrBlockBits)
+	return ones + uint64(bits.OnesCount64(block&(uint64(1)<<(i%rrrBlockBits)-1))), nil
+}
+
+func (bv *rrrBitVector) Rank0(i uint64) (uint64, error) {
+	ones, err := bv.Rank1(i)
+	return i - ones, err
+}
+
+func (bv *rrrBitVector) Rank(i uint64, b bool) (uint64, error) {
+	if b {
+		return bv.Rank1(i)
+	}
+	return bv.Rank0(i)
+}
+
+// Select returns the position of the (x+1)-th b.
+func (bv *rrrBitVector) Select(x uint64, b bool) (uint64, error) {
+	if x >= bv.NumOfBits(b) {
+		return 0, ErrRankTooLarge
+	}
+	// count returns the number of b in the first n bits whose number of 1 bits is ones.
+	count := func(n, ones uint64) uint64 {
+		if b {
+			return ones
+		}
+		return n - ones
+	}
+	super := uint64(sort.Search(len(bv.superRanks), func(j int) bool {
+		return count(uint64(j)*rrrSuperBlocks*rrrBlockBits, bv.superRanks[j]) > x
+	})) - 1
+	rank := count(super*rrrSuperBlocks*rrrBlockBits, bv.superRanks[super])
+	offset := bv.superOffsets[super]
+	for blk := super * rrrSuperBlocks; ; blk++ {
+		class := bv.class(blk)
+		n := count(rrrBlockBits, class)
+		if rank+n > x {
+			block := rrrDecode(getBits(bv.offsets, offset, rrrOffsetWidth[class]), class)
+			if !b {
+				block = ^block
+			}
+			for x -= rank; x > 0; x-- {
+				block &= block - 1
+			}
+			return blk*rrrBlockBits + uint64(bits.TrailingZeros64(block)), nil
+		}
+		rank += n
+		offset += rrrOffsetWidth[class]
+	}
+}
+
+// MarshalBinary encodes the size, the classes and the offsets. The samples of superblocks are rebuilt on restoring.
+func (bv *rrrBitVector) MarshalBinary() ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, bv.size)
+	binary.Write(buffer, binary.LittleEndian, bv.offsetBits)
+	binary.Write(buffer, binary.LittleEndian, bv.classes)
+	binary.Write(buffer, binary.LittleEndian, bv.offsets)
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalBinary restores the bit vector from the binary created by MarshalBinary.
+func (bv *rrrBitVector) UnmarshalBinary(data []byte) error {
+	dataLen := uint64(len(data))
+	if dataLen < 2*sizeOfInt64 || dataLen%sizeOfInt64 != 0 {
+		return ErrorInvalidFormat
+	}
+	bv.size = binary.LittleEndian.Uint64(data)
+	bv.offsetBits = binary.LittleEndian.Uint64(data[sizeOfInt64:])
+	wordsLen := dataLen/sizeOfInt64 - 2
+	if bv.size > NotFound-rrrBlockBits || bv.blockNum() > wordsLen*(64/rrrClassBits) {
+		return ErrorInvalidFormat
+	}
+	classesLen := (bv.blockNum()*rrrClassBits + 63) / 64
+	words := make([]uint64, wordsLen)
+	binary.Read(bytes.NewReader(data[2*sizeOfInt64:]), binary.LittleEndian, words)
+	bv.classes = words[:classesLen]
+	bv.offsets = words[classesLen:]
+
+	// The offsets are as wide as the sum of the widths of the classes.
+	offsetBits := uint64(0)
+	blockNum := bv.blockNum()
+	for blk := uint64(0); blk < blockNum; blk++ {
+		offsetBits += rrrOffsetWidth[bv.class(blk)]
+	}
+	if offsetBits != bv.offsetBits || uint64(len(bv.offsets)) != (offsetBits+63)/64 {
+		return ErrorInvalidFormat
+	}
+
+	// Every block must be decoded into the bits within the size.
+	offsetBits = 0
+	for blk := uint64(0); blk < blockNum; blk++ {
+		class := bv.class(blk)
+		width := rrrOffsetWidth[class]
+		offset := getBits(bv.offsets, offsetBits, width)
+		if offset >= rrrBinomial[rrrBlockBits][class] || rrrDecode(offset, class)>>(bv.size-blk*rrrBlockBits) != 0 {
+			return ErrorInvalidFormat
+		}
+		offsetBits += width
+	}
+	bv.buildSuperBlocks()
+	return nil
+}
+
+// rrrEncode returns the offset of block among the blocks of the class, in lexicographic order.
+func rrrEncode(block, class uint64) uint64 {
+	offset := uint64(0)
+	for pos := rrrBlockBits; pos > 0 && class > 0; pos-- {
+		if (block>>(pos-1))&1 == 1 {
+			offset += rrrBinomial[pos-1][class]
+			class--
+		}
+	}
+	return offset
+}
+
+// rrrDecode returns the block of the offset among the blocks of the class.
+func rrrDecode(offset, class uint64) uint64 {
+	block := uint64(0)
+	for pos := rrrBlockBits; pos > 0 && class > 0; pos-- {
+		if offset >= rrrBinomial[pos-1][class] {
+			offset -= rrrBinomial[pos-1][class]
+			block |= uint64(1) << (pos - 1)
+			class--
+		}
+	}
+	return block
+}
+
+// getBits returns width(<= 64) bits from the position pos of words. The bits beyond words are 0.
+func getBits(words []uint64, pos, width uint64) uint64 {
+	if width == 0 {
+		return 0
+	}
+	i, shift := pos/64, pos%64
+	x := uint64(0)
+	if i < uint64(len(words)) {
+		x = words[i] >> shift
+	}
+	if shift+width > 64 && i+1 < uint64(len(words)) {
+		x |= words[i+1] << (64 - shift)
+	}
+	if width < 64 {
+		x &= uint64(1)<<width - 1
+	}
+	return x
+}
+
+// putBits sets width(< 64) bits of x at the position pos of words, extending words as needed.
+func putBits(words []uint64, pos, x, width uint64) []uint64 {
+	if width == 0 {
+		return words
+	}
+	for uint64(len(words))*64 < pos+width {
+		words = append(words, 0)
+	}
+	i, shift := pos/64, pos%64
+	words[i] |= x << shift
+	if shift+width > 64 {
+		words[i+1] |= x >> (64 - shift)
+	}
+	return words
+}
@@ -0,0 +1,248 @@
+package waveletmatrix
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// RunLengthWaveletMatrix is interface of run-length compressed Wavelet-Matrix.
+type RunLengthWaveletMatrix interface {
+	Size() uint64
+	NumOfRuns() uint64
+	Lookup(pos uint64) (uint64, bool)
+	Rank(c, pos uint64) (uint64, bool)
+	Select(c, rank uint64) (uint64, bool)
+	Freq(c uint64) uint64
+	FreqRange(minC, maxC, begPos, endPos uint64) uint64
+}
+
+// RunLengthWMData holds information of run-length compressed Wavelet-Matrix.
+// The heads of the runs are stored in Wavelet-Matrix weighted by the lengths of the runs.
+// starts holds the first position of every run in the array, and sortedStarts holds the first position of every run
+// in the concatenation of the runs sorted by their heads, where the runs of the same head keep their order.
+type RunLengthWMData struct {
+	size         uint64
+	heads        *WMData
+	starts       *eliasFano
+	sortedStarts *eliasFano
+}
+
+// eliasFano is the sorted positions less than size encoded by Elias-Fano.
+// The lower lowWidth bits of the positions are packed in low, and the upper bits are unary coded in high,
+// where the i-th position sets the bit at (upper bits) + i. It takes about 2 + log(size/n) bits per position.
+type eliasFano struct {
+	n        uint64
+	size     uint64
+	lowWidth uint64
+	low      []uint64
+	high     bitVector
+}
+
+// NewRunLengthWM builds run-length compressed Wavelet-Matrix from src.
+// The queries take the time of Wavelet-Matrix of the heads of the runs. The heads and the first positions of the runs
+// take space proportional to the number of runs, not to the size of the array.
+// Of opts, only WithSelectIndex and WithBitVectorBackend are applied.
+func NewRunLengthWM(src []uint64, opts ...Option) (RunLengthWaveletMatrix, error) {
+	if len(src) == 0 {
+		return nil, ErrorEmpty
+	}
+	builder := newWMBuilder(opts)
+	starts := []uint64{}
+	heads := []uint64{}
+	lengths := []uint64{}
+	for i, c := range src {
+		if i == 0 || c != src[i-1] {
+			starts = append(starts, uint64(i))
+			heads = append(heads, c)
+			lengths = append(lengths, 0)
+		}
+		lengths[len(lengths)-1]++
+	}
+	headsWM, err := NewWeightedWM(heads, lengths, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]int, len(heads))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return heads[order[i]] < heads[order[j]] })
+	sortedStarts := make([]uint64, 0, len(order))
+	pos := uint64(0)
+	for _, run := range order {
+		sortedStarts = append(sortedStarts, pos)
+		pos += lengths[run]
+	}
+
+	rlwm := &RunLengthWMData{size: uint64(len(src)), heads: headsWM.(*WMData)}
+	if rlwm.starts, err = newEliasFano(starts, rlwm.size, builder.backend); err != nil {
+		return nil, err
+	}
+	if rlwm.sortedStarts, err = newEliasFano(sortedStarts, rlwm.size, builder.backend); err != nil {
+		return nil, err
+	}
+	return rlwm, nil
+}
+
+// Size returns size of wavelet-matrix
+func (rlwm *RunLengthWMData) Size() uint64 {
+	return rlwm.size
+}
+
+// NumOfRuns returns the number of runs of the array.
+func (rlwm *RunLengthWMData) NumOfRuns() uint64 {
+	return rlwm.heads.Size()
+}
+
+// Lookup returns value of pos-th element of wavelet-matrix.
+// if pos >= (size of wavelet-matrix),  value of second result parameter is false.
+func (rlwm *RunLengthWMData) Lookup(pos uint64) (uint64, bool) {
+	if pos >= rlwm.size {
+		return NotFound, false
+	}
+	return rlwm.heads.Lookup(rlwm.runOf(pos))
+}
+
+// Rank returns the frequency of a character 'c' in the prefix of the array A[0...pos)
+func (rlwm *RunLengthWMData) Rank(c, pos uint64) (uint64, bool) {
+	if pos > rlwm.size {
+		return NotFound, false
+	}
+	if pos == 0 {
+		return 0, true
+	}
+	// The runs before the last one are counted by their lengths, and the last one is counted up to pos.
+	last := rlwm.runOf(pos - 1)
+	rank := rlwm.heads.SumRange(c, c+1, 0, last)
+	if head, _ := rlwm.heads.Lookup(last); head == c {
+		rank += pos - rlwm.runStart(last)
+	}
+	return rank, true
+}
+
+// Select returns the position of the rank-th occurrence of `c` in the array.
+func (rlwm *RunLengthWMData) Select(c, rank uint64) (uint64, bool) {
+	runs := rlwm.heads.Freq(c)
+	if rank == 0 || runs == 0 {
+		return NotFound, false
+	}
+	less := rlwm.heads.RankLessThan(c, rlwm.heads.Size())
+	base := rlwm.sortedRunStart(less)
+	if rlwm.sortedRunStart(less+runs)-base < rank {
+		return NotFound, false
+	}
+	sortedPos := base + rank - 1
+	sortedRun := rlwm.sortedStarts.rank(sortedPos + 1)
+	run, found := rlwm.heads.Select(c, sortedRun-less)
+	if !found {
+		return NotFound, false
+	}
+	return rlwm.runStart(run) + sortedPos - rlwm.sortedRunStart(sortedRun-1), true
+}
+
+// Freq returns the frequency of the character `c`.
+func (rlwm *RunLengthWMData) Freq(c uint64) uint64 {
+	freq, _ := rlwm.Rank(c, rlwm.size)
+	return freq
+}
+
+// FreqRange returns the frequency of characters minC <= c' < maxC in the subarray A[begPos ... endPos)
+func (rlwm *RunLengthWMData) FreqRange(minC, maxC, begPos, endPos uint64) uint64 {
+	if checkListRange(minC, maxC, begPos, endPos, rlwm.size) != nil {
+		return 0
+	}
+	inRange := func(run uint64) bool {
+		head, _ := rlwm.heads.Lookup(run)
+		return minC <= head && head < maxC
+	}
+	first := rlwm.runOf(begPos)
+	last := rlwm.runOf(endPos - 1)
+	if first == last {
+		if inRange(first) {
+			return endPos - begPos
+		}
+		return 0
+	}
+	freq := rlwm.heads.SumRange(minC, maxC, first+1, last)
+	if inRange(first) {
+		freq += rlwm.runStart(first+1) - begPos
+	}
+	if inRange(last) {
+		freq += endPos - rlwm.runStart(last)
+	}
+	return freq
+}
+
+// runOf returns the index of the run which contains pos.
+func (rlwm *RunLengthWMData) runOf(pos uint64) uint64 {
+	return rlwm.starts.rank(pos+1) - 1
+}
+
+// runStart returns the first position of the run-th run.
+func (rlwm *RunLengthWMData) runStart(run uint64) uint64 {
+	return rlwm.starts.selectPos(run)
+}
+
+// sortedRunStart returns the first position of the run-th run in the sorted runs, or `size` for the end of them.
+func (rlwm *RunLengthWMData) sortedRunStart(run uint64) uint64 {
+	if run == rlwm.heads.Size() {
+		return rlwm.size
+	}
+	return rlwm.sortedStarts.selectPos(run)
+}
+
+// newEliasFano encodes positions, which must be sorted and less than size.
+func newEliasFano(positions []uint64, size uint64, backend bitVectorBackend) (*eliasFano, error) {
+	ef := &eliasFano{n: uint64(len(positions)), size: size}
+	if ef.n > 0 && size > ef.n {
+		ef.lowWidth = uint64(bits.Len64(size/ef.n)) - 1
+	}
+	high := backend.NewBuilder(true)
+	next := uint64(0)
+	for i, pos := range positions {
+		ef.low = putBits(ef.low, uint64(i)*ef.lowWidth, pos&(uint64(1)<<ef.lowWidth-1), ef.lowWidth)
+		for bit := pos>>ef.lowWidth + uint64(i); next < bit; next++ {
+			high.Set(next, false)
+		}
+		high.Set(next, true)
+		next++
+	}
+	// Every bucket of the upper bits is terminated by 0, so that the bucket of any position less than size can be selected.
+	if size > 0 {
+		for end := (size-1)>>ef.lowWidth + ef.n + 1; next < end; next++ {
+			high.Set(next, false)
+		}
+	}
+	var err error
+	if ef.high, err = high.Build(); err != nil {
+		return nil, err
+	}
+	return ef, nil
+}
+
+// selectPos returns the i-th position.
+func (ef *eliasFano) selectPos(i uint64) uint64 {
+	bit, _ := ef.high.Select(i, true)
+	return (bit-i)<<ef.lowWidth | getBits(ef.low, i*ef.lowWidth, ef.lowWidth)
+}
+
+// rank returns the number of the positions less than pos.
+func (ef *eliasFano) rank(pos uint64) uint64 {
+	if pos >= ef.size {
+		return ef.n
+	}
+	upper := pos >> ef.lowWidth
+	// The positions of the bucket `upper` are [beg, end) in the order.
+	beg := uint64(0)
+	if upper > 0 {
+		bit, _ := ef.high.Select(upper-1, false)
+		beg = bit + 1 - upper
+	}
+	bit, _ := ef.high.Select(upper, false)
+	end := bit - upper
+	lower := pos & (uint64(1)<<ef.lowWidth - 1)
+	return beg + uint64(sort.Search(int(end-beg), func(j int) bool {
+		return getBits(ef.low, (beg+uint64(j))*ef.lowWidth, ef.lowWidth) >= lower
+	}))
+}
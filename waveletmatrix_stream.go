@@ -0,0 +1,275 @@
+package waveletmatrix
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+// StreamBuilder builds Wavelet-Matrix from values which are given incrementally.
+// The values and the per-level temporaries are spilled to temporary files through bounded buffers,
+// so that only the bit vectors of each level are kept in memory.
+type StreamBuilder struct {
+	alphabetNum    uint64
+	alphabetBitNum uint64
+	width          uint64
+	size           uint64
+	dir            string
+	selectIndex    bool
+	backend        bitVectorBackend
+	files          []*os.File
+	writer         *bufio.Writer
+	buf            []byte
+}
+
+const (
+	streamBufferSize = 1 << 16
+)
+
+var (
+	// ErrorInvalidWidth indicates that the width of the value is not 1, 2, 4 or 8 bytes.
+	ErrorInvalidWidth = errors.New("Width of the value must be 1, 2, 4 or 8.")
+	// ErrorBuilderClosed indicates that the builder has already been built or closed.
+	ErrorBuilderClosed = errors.New("Builder is already closed.")
+)
+
+// NewStreamBuilder returns builder for values less than alphabetNum.
+// The temporary files are created in dir. If dir is the empty string, the default directory for temporary files is used.
+// Of opts, only WithSelectIndex and WithBitVectorBackend are applied.
+func NewStreamBuilder(alphabetNum uint64, dir string, opts ...Option) (*StreamBuilder, error) {
+	if alphabetNum == 0 {
+		return nil, ErrorEmpty
+	}
+	alphabetBitNum := levelNumOf(alphabetNum)
+	builder := newWMBuilder(opts)
+	sb := &StreamBuilder{
+		alphabetNum:    alphabetNum,
+		alphabetBitNum: alphabetBitNum,
+		width:          (alphabetBitNum + 7) / 8,
+		dir:            dir,
+		selectIndex:    builder.selectIndex,
+		backend:        builder.backend,
+	}
+	sb.buf = make([]byte, sb.width)
+	file, err := os.CreateTemp(dir, "waveletmatrix-")
+	if err != nil {
+		return nil, err
+	}
+	sb.files = []*os.File{file}
+	sb.writer = bufio.NewWriterSize(file, streamBufferSize)
+	return sb, nil
+}
+
+// NewWMFromStream builds Wavelet-Matrix from little-endian fixed-width(1, 2, 4 or 8 bytes) integers read from r.
+func NewWMFromStream(r io.Reader, width int, alphabetNum uint64) (WaveletMatrix, error) {
+	sb, err := NewStreamBuilder(alphabetNum, "")
+	if err != nil {
+		return nil, err
+	}
+	defer sb.Close()
+	if err := sb.AddFromReader(r, width); err != nil {
+		return nil, err
+	}
+	return sb.Build()
+}
+
+// Size returns the number of values added to the builder.
+func (sb *StreamBuilder) Size() uint64 {
+	return sb.size
+}
+
+// Add appends c to the end of the array.
+func (sb *StreamBuilder) Add(c uint64) error {
+	if sb.writer == nil {
+		return ErrorBuilderClosed
+	}
+	if c >= sb.alphabetNum {
+		return ErrorOutOfAlphabet
+	}
+	putValue(sb.buf, c)
+	if _, err := sb.writer.Write(sb.buf); err != nil {
+		return err
+	}
+	sb.size++
+	return nil
+}
+
+// AddFromFunc appends the values returned by next until its second result is false.
+func (sb *StreamBuilder) AddFromFunc(next func() (uint64, bool)) error {
+	for {
+		c, ok := next()
+		if !ok {
+			return nil
+		}
+		if err := sb.Add(c); err != nil {
+			return err
+		}
+	}
+}
+
+// AddFromChan appends the values received from ch until it is closed.
+func (sb *StreamBuilder) AddFromChan(ch <-chan uint64) error {
+	for c := range ch {
+		if err := sb.Add(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddFromReader appends little-endian fixed-width(1, 2, 4 or 8 bytes) integers read from r until io.EOF.
+func (sb *StreamBuilder) AddFromReader(r io.Reader, width int) error {
+	if width != 1 && width != 2 && width != 4 && width != 8 {
+		return ErrorInvalidWidth
+	}
+	reader := bufio.NewReaderSize(r, streamBufferSize)
+	buf := make([]byte, width)
+	for {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := sb.Add(getValue(buf)); err != nil {
+			return err
+		}
+	}
+}
+
+// Build builds Wavelet-Matrix from the values added so far. The temporary files are removed.
+func (sb *StreamBuilder) Build() (WaveletMatrix, error) {
+	if sb.writer == nil {
+		return nil, ErrorBuilderClosed
+	}
+	defer sb.Close()
+	if sb.size == 0 {
+		return nil, ErrorEmpty
+	}
+	if err := sb.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	wm := &WMData{}
+	wm.size = sb.size
+	wm.alphabetNum = sb.alphabetNum
+	wm.alphabetBitNum = sb.alphabetBitNum
+	wm.zeros = make([]uint64, sb.alphabetBitNum)
+	wm.bv = make([]bitVector, sb.alphabetBitNum)
+	wm.backend = sb.backend
+
+	for i := uint64(0); i < sb.alphabetBitNum; i++ {
+		if err := sb.buildLevel(wm, i); err != nil {
+			return nil, err
+		}
+	}
+	return wm, nil
+}
+
+// buildLevel sets the bits of level i from the current temporary files, and partitions them by the bit
+// into the temporary files for the next level.
+func (sb *StreamBuilder) buildLevel(wm *WMData, i uint64) error {
+	readers := make([]io.Reader, len(sb.files))
+	for j, file := range sb.files {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		readers[j] = file
+	}
+	reader := bufio.NewReaderSize(io.MultiReader(readers...), streamBufferSize)
+
+	last := i+1 == sb.alphabetBitNum
+	var nextFiles []*os.File
+	var writers [2]*bufio.Writer
+	if !last {
+		for b := 0; b < 2; b++ {
+			file, err := os.CreateTemp(sb.dir, "waveletmatrix-")
+			if err != nil {
+				sb.removeFiles(nextFiles)
+				return err
+			}
+			nextFiles = append(nextFiles, file)
+			writers[b] = bufio.NewWriterSize(file, streamBufferSize)
+		}
+	}
+
+	shift := sb.alphabetBitNum - i - 1
+	bvBuilder := sb.backend.NewBuilder(sb.selectIndex)
+	zeros := uint64(0)
+	for j := uint64(0); j < sb.size; j++ {
+		if _, err := io.ReadFull(reader, sb.buf); err != nil {
+			sb.removeFiles(nextFiles)
+			return err
+		}
+		c := getValue(sb.buf)
+		bit := (c >> shift) & 1
+		bvBuilder.Set(j, toBool(bit))
+		if bit == 0 {
+			zeros++
+		}
+		if !last {
+			if _, err := writers[bit].Write(sb.buf); err != nil {
+				sb.removeFiles(nextFiles)
+				return err
+			}
+		}
+	}
+	for _, writer := range writers {
+		if writer == nil {
+			continue
+		}
+		if err := writer.Flush(); err != nil {
+			sb.removeFiles(nextFiles)
+			return err
+		}
+	}
+
+	bv, err := bvBuilder.Build()
+	if err != nil {
+		sb.removeFiles(nextFiles)
+		return err
+	}
+	wm.bv[i] = bv
+	wm.zeros[i] = zeros
+
+	if !last {
+		sb.removeFiles(sb.files)
+		sb.files = nextFiles
+	}
+	return nil
+}
+
+// Close removes the temporary files. The builder can not be used after Close.
+func (sb *StreamBuilder) Close() error {
+	if sb.writer == nil {
+		return nil
+	}
+	sb.writer = nil
+	return sb.removeFiles(sb.files)
+}
+
+func (sb *StreamBuilder) removeFiles(files []*os.File) error {
+	var firstErr error
+	for _, file := range files {
+		file.Close()
+		if err := os.Remove(file.Name()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func putValue(buf []byte, c uint64) {
+	for i := 0; i < len(buf); i++ {
+		buf[i] = byte(c >> (uint(i) * 8))
+	}
+}
+
+func getValue(buf []byte) uint64 {
+	c := uint64(0)
+	for i := 0; i < len(buf); i++ {
+		c |= uint64(buf[i]) << (uint(i) * 8)
+	}
+	return c
+}
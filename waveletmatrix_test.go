@@ -1,6 +1,14 @@
 package waveletmatrix
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -212,3 +220,1806 @@ func TestMarshalize(t *testing.T) {
 		}
 	}
 }
+
+func TestCompact(t *testing.T) {
+	src := []uint64{1 << 40, 7, 1 << 40, 1<<62 + 3, 99, 7, 1 << 40, 5}
+	wm, err := NewCompactWM(src)
+	if err != nil {
+		t.Error("Unexpected error in NewCompactWM()")
+	}
+	if wm.Size() != uint64(len(src)) {
+		t.Error("Exprected", len(src), "Got", wm.Size())
+	}
+	for i := 0; i < len(src); i++ {
+		v, found := wm.Lookup(uint64(i))
+		if !found {
+			t.Error("Not Found:", i)
+		}
+		if v != src[i] {
+			t.Error("Expected", src[i], "Got", v)
+		}
+	}
+	if r, _ := wm.Rank(1<<40, 7); r != uint64(3) {
+		t.Error("Expected", 3, "Got", r)
+	}
+	if r, found := wm.Rank(8, 7); !found || r != uint64(0) {
+		t.Error("Expected", 0, "Got", r)
+	}
+	if pos, _ := wm.Select(7, 2); pos != uint64(5) {
+		t.Error("Expected", 5, "Got", pos)
+	}
+	if _, found := wm.Select(8, 1); found {
+		t.Error("Unexpected")
+	}
+	if f := wm.Freq(1 << 40); f != uint64(3) {
+		t.Error("Expected", 3, "Got", f)
+	}
+	if f := wm.Freq(8); f != uint64(0) {
+		t.Error("Expected", 0, "Got", f)
+	}
+	if r := wm.RankLessThan(8, 8); r != uint64(3) {
+		t.Error("Expected", 3, "Got", r)
+	}
+	if r := wm.RankMoreThan(8, 8); r != uint64(5) {
+		t.Error("Expected", 5, "Got", r)
+	}
+	if f := wm.FreqRange(6, 1<<41, 0, 8); f != uint64(6) {
+		t.Error("Expected", 6, "Got", f)
+	}
+	if f := wm.FreqSum(100, 1<<63); f != uint64(4) {
+		t.Error("Expected", 4, "Got", f)
+	}
+	pos, val := wm.MaxRange(0, 8)
+	if pos != uint64(3) || val != 1<<62+3 {
+		t.Error("Expected", 3, 1<<62+3, "Got", pos, val)
+	}
+	result := wm.ListMinRange(6, 1<<41, 0, 8, 2)
+	if size := len(result); size != 2 {
+		t.Error("Expected", 2, "Got", size)
+	}
+	if result[0].C != uint64(7) || result[0].Freq != uint64(2) {
+		t.Error("Expected", 7, 2, "Got", result[0].C, result[0].Freq)
+	}
+	if result[1].C != uint64(99) || result[1].Freq != uint64(1) {
+		t.Error("Expected", 99, 1, "Got", result[1].C, result[1].Freq)
+	}
+	if result := wm.ListMaxRange(100, 1000, 0, 8, 2); len(result) != 0 {
+		t.Error("Expected", 0, "Got", len(result))
+	}
+
+	buf, _ := wm.MarshalBinary()
+	wm2, err := NewCompactWMFromBinary(buf)
+	if err != nil {
+		t.Error("Unexpected error in UnmarshalBinary()")
+	}
+	for i := 0; i < len(src); i++ {
+		if v, _ := wm2.Lookup(uint64(i)); v != src[i] {
+			t.Error("Expected", src[i], "Got", v)
+		}
+	}
+}
+
+func TestLargeAlphabet(t *testing.T) {
+	src := []uint64{1 << 31, 3, 1<<32 - 1, 3, 1 << 31, 0}
+	wm, err := NewWM(src)
+	if err != nil {
+		t.Error("Unexpected error in NewWM()")
+	}
+	for i := 0; i < len(src); i++ {
+		if v, _ := wm.Lookup(uint64(i)); v != src[i] {
+			t.Error("Expected", src[i], "Got", v)
+		}
+	}
+	if r, _ := wm.Rank(1<<31, 5); r != uint64(2) {
+		t.Error("Expected", 2, "Got", r)
+	}
+	if pos, _ := wm.Select(3, 2); pos != uint64(3) {
+		t.Error("Expected", 3, "Got", pos)
+	}
+	if pos, _ := wm.SelectFromPos(1<<31, 1, 1); pos != uint64(4) {
+		t.Error("Expected", 4, "Got", pos)
+	}
+	pos, val := wm.QuantileRange(0, 6, 3)
+	if pos != uint64(0) || val != 1<<31 {
+		t.Error("Expected", 0, 1<<31, "Got", pos, val)
+	}
+	pos, val = wm.MaxRange(0, 6)
+	if pos != uint64(2) || val != 1<<32-1 {
+		t.Error("Expected", 2, 1<<32-1, "Got", pos, val)
+	}
+	if f := wm.FreqRange(1, 1<<32, 0, 6); f != uint64(5) {
+		t.Error("Expected", 5, "Got", f)
+	}
+}
+
+func TestUnmarshalLegacy(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	wm, _ := NewWM(src)
+	data := wm.(*WMData)
+
+	// Legacy format: size, alphabetNum, alphabetBitNum, bit vectors, node position tables and separators.
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, data.size)
+	binary.Write(buffer, binary.LittleEndian, data.alphabetNum)
+	binary.Write(buffer, binary.LittleEndian, data.alphabetBitNum)
+	binary.Write(buffer, binary.LittleEndian, uint64(len(data.bv)))
+	for i := 0; i < len(data.bv); i++ {
+		buf, _ := data.bv[i].MarshalBinary()
+		binary.Write(buffer, binary.LittleEndian, uint64(len(buf)))
+		binary.Write(buffer, binary.LittleEndian, buf)
+	}
+	binary.Write(buffer, binary.LittleEndian, uint64(len(data.zeros)))
+	for i := 0; i < len(data.zeros); i++ {
+		// The beginning position of the node of prefix p is the number of elements whose bit-reversed prefix is smaller.
+		width := uint(i + 1)
+		nodePos := make([]uint64, 1<<width)
+		for p := range nodePos {
+			for _, c := range src {
+				if bits.Reverse64(c>>(data.alphabetBitNum-uint64(width))) < bits.Reverse64(uint64(p)) {
+					nodePos[p]++
+				}
+			}
+		}
+		binary.Write(buffer, binary.LittleEndian, uint64(len(nodePos)))
+		binary.Write(buffer, binary.LittleEndian, nodePos)
+	}
+	binary.Write(buffer, binary.LittleEndian, uint64(0))
+
+	wm2, err := NewWMFromBinary(buffer.Bytes())
+	if err != nil {
+		t.Error("Unexpected error in UnmarshalBinary()")
+	}
+	for i := 0; i < len(src); i++ {
+		if v, _ := wm2.Lookup(uint64(i)); v != src[i] {
+			t.Error("Expected", src[i], "Got", v)
+		}
+	}
+	if r, _ := wm2.Rank(2, 6); r != uint64(2) {
+		t.Error("Expected", 2, "Got", r)
+	}
+
+	if _, err := NewWMFromBinary(buffer.Bytes()[:buffer.Len()-1]); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+}
+
+func TestGeneric(t *testing.T) {
+	src := []int16{-3, 5, -300, 0, 5, -3, 32767, -32768}
+	wm, err := New(src)
+	if err != nil {
+		t.Error("Unexpected error in New()")
+	}
+	for i := 0; i < len(src); i++ {
+		v, found := wm.Lookup(uint64(i))
+		if !found {
+			t.Error("Not Found:", i)
+		}
+		if v != src[i] {
+			t.Error("Expected", src[i], "Got", v)
+		}
+	}
+	if r, _ := wm.Rank(-3, 6); r != uint64(2) {
+		t.Error("Expected", 2, "Got", r)
+	}
+	if pos, _ := wm.Select(5, 2); pos != uint64(4) {
+		t.Error("Expected", 4, "Got", pos)
+	}
+	if r := wm.RankLessThan(0, 8); r != uint64(4) {
+		t.Error("Expected", 4, "Got", r)
+	}
+	if f := wm.FreqRange(-300, 1, 0, 8); f != uint64(4) {
+		t.Error("Expected", 4, "Got", f)
+	}
+	pos, val := wm.MinRange(0, 8)
+	if pos != uint64(7) || val != -32768 {
+		t.Error("Expected", 7, -32768, "Got", pos, val)
+	}
+	pos, val = wm.QuantileRange(0, 7, 1)
+	if pos != uint64(0) || val != -3 {
+		t.Error("Expected", 0, -3, "Got", pos, val)
+	}
+	if pos, _ := wm.QuantileRange(0, 9, 1); pos != NotFound {
+		t.Error("Expected", NotFound, "Got", pos)
+	}
+	result := wm.ListMinRange(-1000, 1000, 0, 8, 3)
+	if size := len(result); size != 3 {
+		t.Error("Expected", 3, "Got", size)
+	}
+	if result[0].C != -300 || result[1].C != -3 || result[2].C != 0 {
+		t.Error("Expected", -300, -3, 0, "Got", result[0].C, result[1].C, result[2].C)
+	}
+
+	buf, _ := wm.MarshalBinary()
+	wm2, err := NewFromBinary[int16](buf)
+	if err != nil {
+		t.Error("Unexpected error in UnmarshalBinary()")
+	}
+	for i := 0; i < len(src); i++ {
+		if v, _ := wm2.Lookup(uint64(i)); v != src[i] {
+			t.Error("Expected", src[i], "Got", v)
+		}
+	}
+
+	wm3, err := New([]uint32{7, 1 << 31, 7})
+	if err != nil {
+		t.Error("Unexpected error in New()")
+	}
+	if f := wm3.Freq(7); f != uint64(2) {
+		t.Error("Expected", 2, "Got", f)
+	}
+
+	// The bounds above the largest value count every element below them.
+	wm4, _ := New([]int8{-5, 3, 7, -1})
+	if r := wm4.RankLessThan(100, 4); r != uint64(4) {
+		t.Error("Expected", 4, "Got", r)
+	}
+	if r := wm4.RankMoreThan(100, 4); r != uint64(0) {
+		t.Error("Expected", 0, "Got", r)
+	}
+	if rank, less, more := wm4.RankAll(100, 1, 4); rank != 0 || less != 3 || more != 0 {
+		t.Error("Expected", 0, 3, 0, "Got", rank, less, more)
+	}
+	if f := wm4.FreqSum(-1, 100); f != uint64(3) {
+		t.Error("Expected", 3, "Got", f)
+	}
+	if f := wm4.FreqRange(0, 100, 0, 3); f != uint64(2) {
+		t.Error("Expected", 2, "Got", f)
+	}
+	if r := wm4.RankLessThan(100, 5); r != NotFound {
+		t.Error("Expected", NotFound, "Got", r)
+	}
+
+	if _, err := New([]int64{1, 1<<63 - 1}); err != ErrorReservedValue {
+		t.Error("Expected", ErrorReservedValue, "Got", err)
+	}
+}
+
+func TestStreamBuilder(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	sb, err := NewStreamBuilder(6, "")
+	if err != nil {
+		t.Error("Unexpected error in NewStreamBuilder()")
+	}
+	i := 0
+	sb.AddFromFunc(func() (uint64, bool) {
+		if i == 4 {
+			return 0, false
+		}
+		i++
+		return src[i-1], true
+	})
+	ch := make(chan uint64, 4)
+	for _, c := range src[4:] {
+		ch <- c
+	}
+	close(ch)
+	sb.AddFromChan(ch)
+	if err := sb.Add(6); err != ErrorOutOfAlphabet {
+		t.Error("Expected", ErrorOutOfAlphabet, "Got", err)
+	}
+	wm, err := sb.Build()
+	if err != nil {
+		t.Error("Unexpected error in Build()")
+	}
+	expected, _ := NewWM(src)
+	buf1, _ := wm.MarshalBinary()
+	buf2, _ := expected.MarshalBinary()
+	if !bytes.Equal(buf1, buf2) {
+		t.Error("Result of StreamBuilder differs from NewWM()")
+	}
+	if err := sb.Add(1); err != ErrorBuilderClosed {
+		t.Error("Expected", ErrorBuilderClosed, "Got", err)
+	}
+
+	buffer := new(bytes.Buffer)
+	binary.Write(buffer, binary.LittleEndian, []uint16{300, 7, 300, 1})
+	wm, err = NewWMFromStream(buffer, 2, 301)
+	if err != nil {
+		t.Error("Unexpected error in NewWMFromStream()")
+	}
+	if r, _ := wm.Rank(300, 4); r != uint64(2) {
+		t.Error("Expected", 2, "Got", r)
+	}
+	if _, err := NewWMFromStream(bytes.NewReader([]byte{1, 1, 3}), 2, 301); err != io.ErrUnexpectedEOF {
+		t.Error("Expected", io.ErrUnexpectedEOF, "Got", err)
+	}
+}
+
+func TestBuildParallel(t *testing.T) {
+	src := make([]uint64, 1000)
+	for i := 0; i < len(src); i++ {
+		src[i] = uint64(i*7919) % 301
+	}
+	expected, _ := NewWM(src)
+	buf1, _ := expected.MarshalBinary()
+	for _, workers := range []int{2, 3, 8, 64} {
+		wm, err := NewWMParallel(src, workers)
+		if err != nil {
+			t.Error("Unexpected error in NewWMParallel()")
+		}
+		buf2, _ := wm.MarshalBinary()
+		if !bytes.Equal(buf1, buf2) {
+			t.Error("Result of NewWMParallel() differs from NewWM() with", workers, "workers")
+		}
+	}
+}
+
+func TestBuildWithOptions(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	wm, err := NewWMWithOptions(src, WithAlphabetSize(100), WithSelectIndex(false), WithWorkers(2))
+	if err != nil {
+		t.Error("Unexpected error in NewWMWithOptions()")
+	}
+	if n := wm.(*WMData).alphabetBitNum; n != uint64(7) {
+		t.Error("Expected", 7, "Got", n)
+	}
+	for i := 0; i < len(src); i++ {
+		if v, _ := wm.Lookup(uint64(i)); v != src[i] {
+			t.Error("Expected", src[i], "Got", v)
+		}
+	}
+	if r, found := wm.Rank(50, 8); !found || r != uint64(0) {
+		t.Error("Expected", 0, "Got", r)
+	}
+	if pos, _ := wm.Select(2, 2); pos != uint64(5) {
+		t.Error("Expected", 5, "Got", pos)
+	}
+	pos, val := wm.MaxRange(0, 8)
+	if pos != uint64(0) || val != uint64(5) {
+		t.Error("Expected", 0, 5, "Got", pos, val)
+	}
+
+	if _, err := NewWMWithOptions(src, WithAlphabetSize(5)); err != ErrorOutOfAlphabet {
+		t.Error("Expected", ErrorOutOfAlphabet, "Got", err)
+	}
+}
+
+func TestChecked(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	wm, _ := NewWM(src)
+	cwm := wm.Checked()
+
+	if _, err := cwm.Lookup(8); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+	if r, err := cwm.Rank(0, 0); err != nil || r != uint64(0) {
+		t.Error("Expected", 0, nil, "Got", r, err)
+	}
+	if r, found := wm.Rank(0, 0); !found || r != uint64(0) {
+		t.Error("Expected", 0, true, "Got", r, found)
+	}
+	if _, err := cwm.Rank(6, 3); err != ErrInvalidSymbol {
+		t.Error("Expected", ErrInvalidSymbol, "Got", err)
+	}
+	if _, err := cwm.Rank(1, 9); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+	if r, rl, rm, err := cwm.RankAll(2, 8, 8); err != nil || r != 0 || rl != 0 || rm != 0 {
+		t.Error("Expected", 0, 0, 0, nil, "Got", r, rl, rm, err)
+	}
+	if _, _, _, err := cwm.RankAll(2, 5, 4); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+	if _, err := cwm.Select(2, 0); err != ErrInvalidRank {
+		t.Error("Expected", ErrInvalidRank, "Got", err)
+	}
+	if _, err := cwm.Select(2, 3); err != ErrRankTooLarge {
+		t.Error("Expected", ErrRankTooLarge, "Got", err)
+	}
+	if _, err := cwm.SelectFromPos(2, 9, 1); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+	if pos, err := cwm.SelectFromPos(2, 5, 1); err != nil || pos != uint64(5) {
+		t.Error("Expected", 5, nil, "Got", pos, err)
+	}
+	if _, err := cwm.Freq(10); err != ErrInvalidSymbol {
+		t.Error("Expected", ErrInvalidSymbol, "Got", err)
+	}
+	if _, err := cwm.FreqRange(3, 2, 0, 8); err != ErrInvalidRange {
+		t.Error("Expected", ErrInvalidRange, "Got", err)
+	}
+	if f, err := cwm.FreqRange(2, 100, 0, 8); err != nil || f != uint64(5) {
+		t.Error("Expected", 5, nil, "Got", f, err)
+	}
+	if f, err := cwm.FreqSum(2, 2); err != nil || f != uint64(0) {
+		t.Error("Expected", 0, nil, "Got", f, err)
+	}
+	if _, _, err := cwm.QuantileRange(2, 2, 0); err != ErrRankTooLarge {
+		t.Error("Expected", ErrRankTooLarge, "Got", err)
+	}
+	if _, _, err := cwm.MaxRange(0, 9); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+	if pos, val, err := cwm.MinRange(0, 8); err != nil || pos != uint64(2) || val != uint64(0) {
+		t.Error("Expected", 2, 0, nil, "Got", pos, val, err)
+	}
+	if _, err := cwm.ListMinRange(3, 2, 0, 8, 1); err != ErrInvalidRange {
+		t.Error("Expected", ErrInvalidRange, "Got", err)
+	}
+	if res, err := cwm.ListModeRange(0, 6, 3, 3, 1); err != nil || len(res) != 0 {
+		t.Error("Expected", 0, nil, "Got", len(res), err)
+	}
+
+	cm, _ := NewCompactWM([]uint64{1 << 40, 7, 1 << 40})
+	ccm := cm.Checked()
+	if r, err := ccm.Rank(8, 3); err != nil || r != uint64(0) {
+		t.Error("Expected", 0, nil, "Got", r, err)
+	}
+	if _, err := ccm.Select(8, 1); err != ErrRankTooLarge {
+		t.Error("Expected", ErrRankTooLarge, "Got", err)
+	}
+	if _, err := ccm.Lookup(3); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+	if _, _, err := ccm.QuantileRange(0, 3, 3); err != ErrRankTooLarge {
+		t.Error("Expected", ErrRankTooLarge, "Got", err)
+	}
+}
+
+func TestNextPrevValue(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	wm, _ := NewWM(src)
+	if pos, val := wm.NextValue(1, 7, 2); pos != uint64(4) || val != uint64(2) {
+		t.Error("Expected", 4, 2, "Got", pos, val)
+	}
+	if pos, val := wm.NextValue(0, 8, 6); pos != NotFound || val != NotFound {
+		t.Error("Expected", NotFound, NotFound, "Got", pos, val)
+	}
+	if pos, val := wm.NextValue(4, 8, 3); pos != uint64(7) || val != uint64(3) {
+		t.Error("Expected", 7, 3, "Got", pos, val)
+	}
+	if pos, val := wm.PrevValue(1, 7, 2); pos != uint64(1) || val != uint64(1) {
+		t.Error("Expected", 1, 1, "Got", pos, val)
+	}
+	if pos, val := wm.PrevValue(1, 8, 1); pos != uint64(6) || val != uint64(0) {
+		t.Error("Expected", 6, 0, "Got", pos, val)
+	}
+	if pos, val := wm.PrevValue(0, 8, 100); pos != uint64(0) || val != uint64(5) {
+		t.Error("Expected", 0, 5, "Got", pos, val)
+	}
+	if _, _, err := wm.Checked().PrevValue(0, 8, 0); err != ErrNotFound {
+		t.Error("Expected", ErrNotFound, "Got", err)
+	}
+	if _, _, err := wm.Checked().NextValue(0, 9, 0); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+
+	cwm, _ := NewCompactWM([]uint64{1 << 40, 7, 1 << 40, 99})
+	if pos, val := cwm.NextValue(1, 4, 8); pos != uint64(3) || val != uint64(99) {
+		t.Error("Expected", 3, 99, "Got", pos, val)
+	}
+	if pos, val := cwm.NextValue(0, 4, 100); pos != uint64(0) || val != uint64(1<<40) {
+		t.Error("Expected", 0, 1<<40, "Got", pos, val)
+	}
+	if pos, val := cwm.PrevValue(0, 4, 1<<40); pos != uint64(3) || val != uint64(99) {
+		t.Error("Expected", 3, 99, "Got", pos, val)
+	}
+
+	gwm, _ := New([]int8{-5, 3, -1})
+	if pos, val := gwm.NextValue(0, 3, -4); pos != uint64(2) || val != -1 {
+		t.Error("Expected", 2, -1, "Got", pos, val)
+	}
+	if pos, _ := gwm.PrevValue(0, 3, -5); pos != NotFound {
+		t.Error("Expected", NotFound, "Got", pos)
+	}
+}
+
+func TestPoints(t *testing.T) {
+	points := []Point{{1 << 40, 3}, {5, 7}, {100, 3}, {5, 1}, {1 << 50, 9}, {77, 7}}
+	p, err := NewPoints(points)
+	if err != nil {
+		t.Error("Unexpected error in NewPoints()")
+	}
+	if p.Size() != uint64(len(points)) {
+		t.Error("Expected", len(points), "Got", p.Size())
+	}
+	if c := p.CountRect(5, 1<<40+1, 3, 8); c != uint64(4) {
+		t.Error("Expected", 4, "Got", c)
+	}
+	if c := p.CountRect(6, 1<<50, 0, 100); c != uint64(3) {
+		t.Error("Expected", 3, "Got", c)
+	}
+	if c := p.CountRect(1<<50+1, 1<<51, 0, 100); c != uint64(0) {
+		t.Error("Expected", 0, "Got", c)
+	}
+
+	expected := []Point{{100, 3}, {1 << 40, 3}, {5, 7}, {77, 7}}
+	it := p.ReportRect(5, 1<<40+1, 3, 8)
+	for i := 0; i < len(expected); i++ {
+		pt, found := it.Next()
+		if !found || pt != expected[i] {
+			t.Error("Expected", expected[i], "Got", pt)
+		}
+	}
+	if pt, found := it.Next(); found {
+		t.Error("Unexpected", pt)
+	}
+
+	top := p.TopKRect(0, 1<<60, 0, 9, 3)
+	expected = []Point{{5, 7}, {77, 7}, {100, 3}}
+	if len(top) != len(expected) {
+		t.Error("Expected", len(expected), "Got", len(top))
+	}
+	for i := 0; i < len(top) && i < len(expected); i++ {
+		if top[i] != expected[i] {
+			t.Error("Expected", expected[i], "Got", top[i])
+		}
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	weights := []uint64{10, 20, 30, 40, 50, 60, 70, 80}
+	wm, err := NewWeightedWM(src, weights)
+	if err != nil {
+		t.Error("Unexpected error in NewWeightedWM()")
+	}
+	if s := wm.SumRange(2, 5, 2, 6); s != uint64(150) {
+		t.Error("Expected", 150, "Got", s)
+	}
+	if s := wm.SumRange(0, 100, 0, 8); s != uint64(360) {
+		t.Error("Expected", 360, "Got", s)
+	}
+	if s := wm.SumRange(0, 1, 0, 7); s != uint64(100) {
+		t.Error("Expected", 100, "Got", s)
+	}
+	if s := wm.SumRange(3, 2, 0, 8); s != uint64(0) {
+		t.Error("Expected", 0, "Got", s)
+	}
+	if r, _ := wm.Rank(2, 6); r != uint64(2) {
+		t.Error("Expected", 2, "Got", r)
+	}
+
+	buf, _ := wm.MarshalBinary()
+	wm2, err := NewWMFromBinary(buf)
+	if err != nil {
+		t.Error("Unexpected error in UnmarshalBinary()")
+	}
+	if s := wm2.(WeightedWaveletMatrix).SumRange(1, 3, 1, 8); s != uint64(130) {
+		t.Error("Expected", 130, "Got", s)
+	}
+
+	if _, err := NewWeightedWM(src, weights[1:]); err != ErrorWeightsLength {
+		t.Error("Expected", ErrorWeightsLength, "Got", err)
+	}
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	wm, _ := NewWM(src)
+	buffer := new(bytes.Buffer)
+	n, err := wm.WriteTo(buffer)
+	if err != nil {
+		t.Error("Unexpected error in WriteTo()")
+	}
+	if n != int64(buffer.Len()) {
+		t.Error("Expected", buffer.Len(), "Got", n)
+	}
+	buf, _ := wm.MarshalBinary()
+	if !bytes.Equal(buf, buffer.Bytes()) {
+		t.Error("WriteTo() and MarshalBinary() differ")
+	}
+
+	// Two matrices in a stream are read one after another.
+	wm.WriteTo(buffer)
+	for i := 0; i < 2; i++ {
+		wm2, err := NewWMFromReader(buffer)
+		if err != nil {
+			t.Error("Unexpected error in NewWMFromReader()")
+		}
+		for pos, c := range src {
+			if v, _ := wm2.Lookup(uint64(pos)); v != c {
+				t.Error("Expected", c, "Got", v)
+			}
+		}
+	}
+
+	if _, err := NewWMFromReader(bytes.NewReader(buf[:len(buf)-1])); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+
+	cwm, _ := NewCompactWM([]uint64{100, 3, 7000, 3, 100})
+	buffer.Reset()
+	cwm.WriteTo(buffer)
+	cwm2 := new(CompactWMData)
+	if _, err := cwm2.ReadFrom(buffer); err != nil {
+		t.Error("Unexpected error in ReadFrom()")
+	}
+	if v, _ := cwm2.Lookup(2); v != uint64(7000) {
+		t.Error("Expected", 7000, "Got", v)
+	}
+}
+
+func TestMapped(t *testing.T) {
+	src := make([]uint64, 3000)
+	for i := range src {
+		src[i] = uint64(i*7919) % 37
+	}
+	wm, _ := NewWM(src)
+	path := filepath.Join(t.TempDir(), "wm.bin")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wm.(*WMData).WriteMappedTo(file); err != nil {
+		t.Error("Unexpected error in WriteMappedTo()")
+	}
+	file.Close()
+
+	mwm, err := OpenMappedWM(path)
+	if err != nil {
+		t.Fatal("Unexpected error in OpenMappedWM()", err)
+	}
+	if mwm.Size() != wm.Size() {
+		t.Error("Expected", wm.Size(), "Got", mwm.Size())
+	}
+	for pos := uint64(0); pos < mwm.Size(); pos += 7 {
+		expected, _ := wm.Lookup(pos)
+		if v, _ := mwm.Lookup(pos); v != expected {
+			t.Error("Expected", expected, "Got", v)
+		}
+		expected, _ = wm.Rank(src[pos], pos)
+		if r, _ := mwm.Rank(src[pos], pos); r != expected {
+			t.Error("Expected", expected, "Got", r)
+		}
+		expected, _ = wm.Select(src[pos], 3)
+		if p, _ := mwm.Select(src[pos], 3); p != expected {
+			t.Error("Expected", expected, "Got", p)
+		}
+		expectedPos, expectedVal := wm.QuantileRange(pos/2, pos+1, pos/4)
+		if p, v := mwm.QuantileRange(pos/2, pos+1, pos/4); p != expectedPos || v != expectedVal {
+			t.Error("Expected", expectedPos, expectedVal, "Got", p, v)
+		}
+	}
+	if _, found := mwm.Select(1, mwm.Freq(1)+1); found {
+		t.Error("Expected", false, "Got", found)
+	}
+	if err := mwm.Validate(WithLookupSamples(100)); err != nil {
+		t.Error("Unexpected error in Validate()", err)
+	}
+	if err := mwm.Close(); err != nil {
+		t.Error("Unexpected error in Close()")
+	}
+	if err := mwm.Close(); err != ErrorMappedClosed {
+		t.Error("Expected", ErrorMappedClosed, "Got", err)
+	}
+	if _, found := mwm.Lookup(0); found {
+		t.Error("Expected", false, "Got", found)
+	}
+	if _, found := mwm.Rank(src[0], 0); found {
+		t.Error("Expected", false, "Got", found)
+	}
+	if _, found := mwm.Select(src[0], 1); found {
+		t.Error("Expected", false, "Got", found)
+	}
+	if p, _ := mwm.QuantileRange(0, 1, 0); p != NotFound {
+		t.Error("Expected", NotFound, "Got", p)
+	}
+
+	// pos == size at the end of the last block of rank samples.
+	for _, n := range []int{512, 1024} {
+		src := make([]uint64, n)
+		for i := range src {
+			src[i] = uint64(i*7919) % 37
+		}
+		wm, _ := NewWM(src)
+		buffer := new(bytes.Buffer)
+		wm.(*WMData).WriteMappedTo(buffer)
+		mwm, err := NewMappedWM(buffer.Bytes())
+		if err != nil {
+			t.Fatal("Unexpected error in NewMappedWM()", err)
+		}
+		expected, _ := wm.Rank(5, uint64(n))
+		if r, _ := mwm.Rank(5, uint64(n)); r != expected {
+			t.Error("Expected", expected, "Got", r)
+		}
+		expectedPos, expectedVal := wm.QuantileRange(0, uint64(n), uint64(n-1))
+		if p, v := mwm.QuantileRange(0, uint64(n), uint64(n-1)); p != expectedPos || v != expectedVal {
+			t.Error("Expected", expectedPos, expectedVal, "Got", p, v)
+		}
+	}
+
+	buffer := new(bytes.Buffer)
+	wm.(*WMData).WriteMappedTo(buffer)
+	if _, err := NewMappedWM(buffer.Bytes()[:buffer.Len()-64]); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+	buf, _ := wm.MarshalBinary()
+	if _, err := NewMappedWM(buf); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+	if !bytes.Equal(buffer.Bytes()[:8], formatMagic[:]) {
+		t.Error("Expected", string(formatMagic[:]), "Got", string(buffer.Bytes()[:8]))
+	}
+	if _, err := NewWMFromBinary(buffer.Bytes()); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+
+	// The words of level 0 start at 128, followed by the rank samples at 512.
+	corrupted := append([]byte{}, buffer.Bytes()...)
+	binary.LittleEndian.PutUint64(corrupted[520:], 600)
+	if _, err := NewMappedWM(corrupted); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+	for _, word := range []uint64{0, NotFound} {
+		corrupted = append([]byte{}, buffer.Bytes()...)
+		for offset := 128; offset < 512; offset += 8 {
+			binary.LittleEndian.PutUint64(corrupted[offset:], word)
+		}
+		mwm, err := NewMappedWM(corrupted)
+		if err != nil {
+			t.Fatal("Unexpected error in NewMappedWM()", err)
+		}
+		if err := mwm.Validate(); !errors.Is(err, ErrorInconsistent) {
+			t.Error("Expected", ErrorInconsistent, "Got", err)
+		}
+		// The words which disagree with the samples do not cause panic.
+		for pos := uint64(0); pos < mwm.Size(); pos += 7 {
+			mwm.Lookup(pos)
+			mwm.Select(src[pos], 3)
+			mwm.QuantileRange(pos/2, pos+1, pos/4)
+		}
+	}
+	// The padding after the zero counts is covered only by the checksum.
+	corrupted = append([]byte{}, buffer.Bytes()...)
+	corrupted[100] ^= 0xFF
+	mwm, err = NewMappedWM(corrupted)
+	if err != nil {
+		t.Fatal("Unexpected error in NewMappedWM()", err)
+	}
+	if err := mwm.Validate(); err != ErrorChecksumMismatch {
+		t.Error("Expected", ErrorChecksumMismatch, "Got", err)
+	}
+}
+
+func TestFormatHeader(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	wm, _ := NewWM(src)
+	buf, _ := wm.MarshalBinary()
+	if !bytes.Equal(buf[:8], []byte("WVLTMTRX")) {
+		t.Error("Expected", "WVLTMTRX", "Got", string(buf[:8]))
+	}
+	if v := binary.LittleEndian.Uint32(buf[8:]); v != formatVersion {
+		t.Error("Expected", formatVersion, "Got", v)
+	}
+
+	corrupted := append([]byte{}, buf...)
+	corrupted[len(corrupted)/2] ^= 0x10
+	if _, err := NewWMFromBinary(corrupted); err != ErrorChecksumMismatch && !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorChecksumMismatch, "Got", err)
+	}
+	corrupted = append([]byte{}, buf...)
+	corrupted[len(corrupted)-1] ^= 0x01
+	if _, err := NewWMFromBinary(corrupted); err != ErrorChecksumMismatch {
+		t.Error("Expected", ErrorChecksumMismatch, "Got", err)
+	}
+
+	unknown := append([]byte{}, buf...)
+	binary.LittleEndian.PutUint32(unknown[8:], formatVersion+1)
+	if _, err := NewWMFromBinary(unknown); err != ErrorUnsupportedVersion {
+		t.Error("Expected", ErrorUnsupportedVersion, "Got", err)
+	}
+	unknown = append([]byte{}, buf...)
+	binary.LittleEndian.PutUint32(unknown[12:], 1<<31)
+	if _, err := NewWMFromBinary(unknown); err != ErrorUnsupportedVersion {
+		t.Error("Expected", ErrorUnsupportedVersion, "Got", err)
+	}
+
+	// The format before the magic header: marker, version, and the number of 0 bits of each level.
+	// It is superseded by the magic header, and is rejected instead of being read as the legacy format.
+	data := wm.(*WMData)
+	buffer := new(bytes.Buffer)
+	for _, v := range []uint64{formatMarker, 2, data.size, data.alphabetNum, data.alphabetBitNum, uint64(len(data.bv))} {
+		binary.Write(buffer, binary.LittleEndian, v)
+	}
+	for i := 0; i < len(data.bv); i++ {
+		bvBuf, _ := data.bv[i].MarshalBinary()
+		binary.Write(buffer, binary.LittleEndian, uint64(len(bvBuf)))
+		buffer.Write(bvBuf)
+	}
+	binary.Write(buffer, binary.LittleEndian, uint64(len(data.zeros)))
+	binary.Write(buffer, binary.LittleEndian, data.zeros)
+	if _, err := NewWMFromBinary(buffer.Bytes()); err != ErrorUnsupportedVersion {
+		t.Error("Expected", ErrorUnsupportedVersion, "Got", err)
+	}
+}
+
+func TestUnmarshalCorrupted(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	wm, _ := NewWeightedWM(src, []uint64{1, 2, 3, 4, 5, 6, 7, 8})
+	buf, _ := wm.MarshalBinary()
+
+	// Every corrupted byte is rejected, or results in a matrix which can be queried without panic.
+	for i := 0; i < len(buf); i++ {
+		corrupted := append([]byte{}, buf...)
+		corrupted[i] ^= 0xFF
+		wm2, err := NewWMFromBinary(corrupted)
+		if err == nil {
+			t.Error("Expected error at", i)
+		}
+		wm2.Lookup(3)
+		wm2.Rank(2, 6)
+		wm2.Select(2, 1)
+		wm2.QuantileRange(0, 8, 3)
+		wm2.ListModeRange(0, 8, 0, 8, 3)
+		wm2.(WeightedWaveletMatrix).SumRange(0, 8, 0, 8)
+	}
+	for i := 0; i < len(buf); i++ {
+		if _, err := NewWMFromBinary(buf[:i]); !errors.Is(err, ErrorInvalidFormat) {
+			t.Error("Expected", ErrorInvalidFormat, "Got", err)
+		}
+	}
+
+	// Huge counts do not allocate beyond the data.
+	data := wm.(*WMData)
+	for _, header := range [][]uint64{
+		{data.size, data.alphabetNum, 1 << 62},
+		{data.size, data.alphabetNum, data.alphabetBitNum, 1 << 60},
+		{data.size, data.alphabetNum, data.alphabetBitNum, data.alphabetBitNum, 1 << 62},
+		{data.size, 1 << 40, data.alphabetBitNum},
+	} {
+		buffer := new(bytes.Buffer)
+		binary.Write(buffer, binary.LittleEndian, header)
+		if _, err := NewWMFromBinary(buffer.Bytes()); !errors.Is(err, ErrorInvalidFormat) {
+			t.Error("Expected", ErrorInvalidFormat, "Got", err)
+		}
+	}
+
+	// The values of compact Wavelet-Matrix follow the header: magic, version, flags and the number of values.
+	cwm, _ := NewCompactWM([]uint64{100, 3, 7000, 3, 100})
+	buf, _ = cwm.MarshalBinary()
+	if !bytes.Equal(buf[:8], formatMagic[:]) {
+		t.Error("Expected", string(formatMagic[:]), "Got", string(buf[:8]))
+	}
+	corrupted := append([]byte{}, buf...)
+	binary.LittleEndian.PutUint64(corrupted[16:], 5000)
+	cwm2 := new(CompactWMData)
+	if err := cwm2.UnmarshalBinary(corrupted); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+	if _, found := cwm2.Lookup(0); found {
+		t.Error("Expected", false, "Got", found)
+	}
+	// The corrupted value is detected by the checksum, even if the values are still ascending.
+	corrupted = append([]byte{}, buf...)
+	binary.LittleEndian.PutUint64(corrupted[32:], 101)
+	if err := cwm2.UnmarshalBinary(corrupted); err != ErrorChecksumMismatch {
+		t.Error("Expected", ErrorChecksumMismatch, "Got", err)
+	}
+	if _, err := NewWMFromBinary(buf); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+	buf, _ = wm.MarshalBinary()
+	if err := cwm2.UnmarshalBinary(buf); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+	// The levels decode the ranks up to 3, which exceed the values, even though the alphabet size fits in them.
+	ranks, _ := NewWM([]uint64{0, 3, 1})
+	ranks.(*WMData).alphabetNum = 2
+	buf, _ = (&CompactWMData{wm: ranks.(*WMData), values: []uint64{10, 20}}).MarshalBinary()
+	if err := cwm2.UnmarshalBinary(buf); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	src := make([]uint64, 1000)
+	for i := range src {
+		src[i] = uint64(i*31) % 97
+	}
+	wm, _ := NewWeightedWM(src, src)
+	if err := wm.Validate(WithLookupSamples(100)); err != nil {
+		t.Error("Unexpected error in Validate()", err)
+	}
+
+	data := wm.(*WMData)
+	data.zeros[3]++
+	err := wm.Validate()
+	var levelErr *LevelError
+	if !errors.As(err, &levelErr) || levelErr.Level != 3 || !errors.Is(err, ErrorInconsistent) {
+		t.Error("Expected", "level 3", "Got", err)
+	}
+	data.zeros[3]--
+
+	data.weightSums[2][500]++
+	data.weightSums[2][1000]++
+	if err := wm.Validate(); !errors.Is(err, ErrorInconsistent) {
+		t.Error("Expected", ErrorInconsistent, "Got", err)
+	}
+	data.weightSums = nil
+
+	// A value out of the alphabet is detected only by sampling.
+	data.alphabetNum = 50
+	if err := wm.Validate(); err != nil {
+		t.Error("Unexpected error in Validate()", err)
+	}
+	if err := wm.Validate(WithLookupSamples(1000)); !errors.Is(err, ErrorInconsistent) || errors.As(err, &levelErr) {
+		t.Error("Expected", ErrorInconsistent, "Got", err)
+	}
+	data.alphabetNum = 97
+
+	cwm, _ := NewCompactWM([]uint64{100, 3, 7000, 3, 100})
+	if err := cwm.Validate(WithLookupSamples(10)); err != nil {
+		t.Error("Unexpected error in Validate()", err)
+	}
+
+	buffer := new(bytes.Buffer)
+	data.WriteMappedTo(buffer)
+	mwm, _ := NewMappedWM(buffer.Bytes())
+	if err := mwm.Validate(); err != nil {
+		t.Error("Unexpected error in Validate()", err)
+	}
+	mwm.bv[2].ranks[1]++
+	if err := mwm.Validate(); !errors.As(err, &levelErr) || levelErr.Level != 2 {
+		t.Error("Expected", "level 2", "Got", err)
+	}
+}
+
+// plainBitVector is a bit vector without indexes, to test pluggable backends.
+type plainBitVector struct {
+	bits []bool
+}
+
+type plainBackend struct {
+	name string
+}
+
+func (b plainBackend) Name() string {
+	return b.name
+}
+
+func (b plainBackend) NewBuilder(selectIndex bool) bitVectorBuilder {
+	return &plainBitVector{}
+}
+
+func (b plainBackend) UnmarshalBitVector(data []byte) (bitVector, error) {
+	bv := &plainBitVector{}
+	for _, x := range data {
+		bv.bits = append(bv.bits, x == 1)
+	}
+	return bv, nil
+}
+
+func (bv *plainBitVector) Set(i uint64, b bool) {
+	for uint64(len(bv.bits)) <= i {
+		bv.bits = append(bv.bits, false)
+	}
+	bv.bits[i] = b
+}
+
+func (bv *plainBitVector) Build() (bitVector, error) {
+	return bv, nil
+}
+
+func (bv *plainBitVector) Size() uint64 {
+	return uint64(len(bv.bits))
+}
+
+func (bv *plainBitVector) NumOfBits(b bool) uint64 {
+	rank, _ := bv.Rank(bv.Size(), b)
+	return rank
+}
+
+func (bv *plainBitVector) Get(i uint64) (bool, error) {
+	if i >= bv.Size() {
+		return false, ErrOutOfRange
+	}
+	return bv.bits[i], nil
+}
+
+func (bv *plainBitVector) Rank(i uint64, b bool) (uint64, error) {
+	if i > bv.Size() {
+		return 0, ErrOutOfRange
+	}
+	rank := uint64(0)
+	for _, x := range bv.bits[:i] {
+		if x == b {
+			rank++
+		}
+	}
+	return rank, nil
+}
+
+func (bv *plainBitVector) Rank0(i uint64) (uint64, error) {
+	return bv.Rank(i, false)
+}
+
+func (bv *plainBitVector) Rank1(i uint64) (uint64, error) {
+	return bv.Rank(i, true)
+}
+
+func (bv *plainBitVector) Select(x uint64, b bool) (uint64, error) {
+	for i, y := range bv.bits {
+		if y == b {
+			if x == 0 {
+				return uint64(i), nil
+			}
+			x--
+		}
+	}
+	return 0, ErrRankTooLarge
+}
+
+func (bv *plainBitVector) MarshalBinary() ([]byte, error) {
+	data := make([]byte, len(bv.bits))
+	for i, x := range bv.bits {
+		if x {
+			data[i] = 1
+		}
+	}
+	return data, nil
+}
+
+func TestBitVectorBackend(t *testing.T) {
+	src := []uint64{5, 1, 0, 4, 2, 2, 0, 3}
+	backend := plainBackend{"plain"}
+	for _, opts := range [][]Option{{withBackend(backend)}, {withBackend(backend), WithWorkers(3)}} {
+		wm, err := NewWMWithOptions(src, opts...)
+		if err != nil {
+			t.Error("Unexpected error in NewWMWithOptions()")
+		}
+		if _, ok := wm.(*WMData).bv[0].(*plainBitVector); !ok {
+			t.Error("Bit vector is not of the backend")
+		}
+		if r, _ := wm.Rank(2, 6); r != uint64(2) {
+			t.Error("Expected", 2, "Got", r)
+		}
+		if p, _ := wm.Select(0, 2); p != uint64(6) {
+			t.Error("Expected", 6, "Got", p)
+		}
+		if _, v := wm.QuantileRange(0, 8, 5); v != uint64(3) {
+			t.Error("Expected", 3, "Got", v)
+		}
+		if err := wm.Validate(WithLookupSamples(8)); err != nil {
+			t.Error("Unexpected error in Validate()", err)
+		}
+	}
+
+	wm, _ := NewWMWithOptions(src, withBackend(backend))
+	buf, _ := wm.MarshalBinary()
+	if _, err := NewWMFromBinary(buf); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+	defer func(backends []bitVectorBackend) { bitVectorBackends = backends }(bitVectorBackends)
+	bitVectorBackends = append(bitVectorBackends[:len(bitVectorBackends):len(bitVectorBackends)], backend)
+	wm2, err := NewWMFromBinary(buf)
+	if err != nil {
+		t.Error("Unexpected error in UnmarshalBinary()", err)
+	}
+	if _, ok := wm2.(*WMData).bv[0].(*plainBitVector); !ok {
+		t.Error("Bit vector is not of the backend")
+	}
+	for i := 0; i < len(src); i++ {
+		if v, _ := wm2.Lookup(uint64(i)); v != src[i] {
+			t.Error("Expected", src[i], "Got", v)
+		}
+	}
+
+	// The name too long to be recorded is rejected before writing anything.
+	wm, _ = NewWMWithOptions(src, withBackend(plainBackend{strings.Repeat("x", 256)}))
+	buffer := new(bytes.Buffer)
+	if n, err := wm.WriteTo(buffer); err != ErrorInvalidBackendName || n != 0 || buffer.Len() != 0 {
+		t.Error("Expected", ErrorInvalidBackendName, "Got", n, err)
+	}
+}
+
+func TestRRR(t *testing.T) {
+	src := make([]uint64, 5000)
+	for i := range src {
+		if i%97 == 0 {
+			src[i] = uint64(i % 13)
+		}
+	}
+	wm, _ := NewWM(src)
+	rrr, err := NewWMWithOptions(src, WithBitVectorBackend(RRRBitVectorBackend))
+	if err != nil {
+		t.Error("Unexpected error in NewWMWithOptions()")
+	}
+	for pos := uint64(0); pos < uint64(len(src)); pos += 37 {
+		if v, _ := rrr.Lookup(pos); v != src[pos] {
+			t.Error("Expected", src[pos], "Got", v)
+		}
+		expected, _ := wm.Rank(src[pos], pos)
+		if r, _ := rrr.Rank(src[pos], pos); r != expected {
+			t.Error("Expected", expected, "Got", r)
+		}
+		expected, _ = wm.Select(src[pos], 2)
+		if p, _ := rrr.Select(src[pos], 2); p != expected {
+			t.Error("Expected", expected, "Got", p)
+		}
+	}
+	if err := rrr.Validate(WithLookupSamples(100)); err != nil {
+		t.Error("Unexpected error in Validate()", err)
+	}
+
+	// The level of skewed bits is compressed below 1 bit per element.
+	buf, _ := rrr.(*WMData).bv[0].MarshalBinary()
+	if len(buf) >= len(src)/8 {
+		t.Error("Expected less than", len(src)/8, "Got", len(buf))
+	}
+
+	buf, _ = rrr.MarshalBinary()
+	rrr2, err := NewWMFromBinary(buf)
+	if err != nil {
+		t.Error("Unexpected error in UnmarshalBinary()", err)
+	}
+	if rrr2.(*WMData).backend != rrrBitVectorBackend {
+		t.Error("Expected", rrrBitVectorBackend.Name(), "Got", rrr2.(*WMData).backend.Name())
+	}
+	expectedPos, expectedVal := wm.QuantileRange(0, 5000, 4990)
+	if p, v := rrr2.QuantileRange(0, 5000, 4990); p != expectedPos || v != expectedVal {
+		t.Error("Expected", expectedPos, expectedVal, "Got", p, v)
+	}
+
+	// The offsets of tiny bit vectors may be wider than the bit vectors themselves.
+	for _, tiny := range [][]uint64{{1}, {0, 1}, {3, 0, 2}, {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 7}} {
+		rrr, _ := NewWMWithOptions(tiny, WithBitVectorBackend(RRRBitVectorBackend))
+		buf, _ := rrr.MarshalBinary()
+		rrr2, err := NewWMFromBinary(buf)
+		if err != nil {
+			t.Error("Unexpected error in UnmarshalBinary()", tiny, err)
+			continue
+		}
+		for pos, c := range tiny {
+			if v, _ := rrr2.Lookup(uint64(pos)); v != c {
+				t.Error("Expected", c, "Got", v)
+			}
+		}
+	}
+}
+
+func TestHuffman(t *testing.T) {
+	src := make([]uint64, 3000)
+	for i := range src {
+		switch {
+		case i%50 == 0:
+			src[i] = uint64(1000000 + i%7)
+		case i%5 == 0:
+			src[i] = 42
+		default:
+			src[i] = 3
+		}
+	}
+	cwm, _ := NewCompactWM(src)
+	hwm, err := NewHuffmanWM(src)
+	if err != nil {
+		t.Error("Unexpected error in NewHuffmanWM()", err)
+	}
+	if hwm.Size() != uint64(len(src)) {
+		t.Error("Expected", len(src), "Got", hwm.Size())
+	}
+	for pos := uint64(0); pos < uint64(len(src)); pos += 7 {
+		if v, _ := hwm.Lookup(pos); v != src[pos] {
+			t.Error("Expected", src[pos], "Got", v)
+		}
+		expected, _ := cwm.Rank(src[pos], pos)
+		if r, _ := hwm.Rank(src[pos], pos); r != expected {
+			t.Error("Expected", expected, "Got", r)
+		}
+		expected, _ = cwm.SelectFromPos(src[pos], pos, 2)
+		if p, _ := hwm.SelectFromPos(src[pos], pos, 2); p != expected {
+			t.Error("Expected", expected, "Got", p)
+		}
+	}
+	for _, c := range []uint64{3, 42, 1000000, 1000006} {
+		if f := hwm.Freq(c); f != cwm.Freq(c) {
+			t.Error("Expected", cwm.Freq(c), "Got", f)
+		}
+	}
+	if f := hwm.Freq(7); f != 0 {
+		t.Error("Expected", 0, "Got", f)
+	}
+	if _, found := hwm.Select(7, 1); found {
+		t.Error("Expected", false, "Got", found)
+	}
+	if _, found := hwm.Rank(3, uint64(len(src))+1); found {
+		t.Error("Expected", false, "Got", found)
+	}
+
+	// The most frequent value has the shortest code.
+	codes := hwm.(*HuffmanWMData).codes
+	if codes[3].length != 1 || codes[1000000].length <= codes[42].length {
+		t.Error("Expected", "short code for frequent value", "Got", codes[3].length, codes[42].length, codes[1000000].length)
+	}
+
+	hwm, _ = NewHuffmanWM([]uint64{5, 5, 5})
+	if v, _ := hwm.Lookup(2); v != 5 {
+		t.Error("Expected", 5, "Got", v)
+	}
+	if p, _ := hwm.Select(5, 3); p != 2 {
+		t.Error("Expected", 2, "Got", p)
+	}
+
+	if _, err := NewHuffmanWM([]uint64{}); err != ErrorEmpty {
+		t.Error("Expected", ErrorEmpty, "Got", err)
+	}
+	if _, err := NewHuffmanWM([]uint64{1, NotFound}); err != ErrorReservedValue {
+		t.Error("Expected", ErrorReservedValue, "Got", err)
+	}
+}
+
+func TestRunLength(t *testing.T) {
+	src := []uint64{}
+	for i := 0; i < 200; i++ {
+		for j := 0; j < 1+i%9; j++ {
+			src = append(src, uint64((i*7)%5)*100)
+		}
+	}
+	wm, _ := NewWM(src)
+	rlwm, err := NewRunLengthWM(src)
+	if err != nil {
+		t.Error("Unexpected error in NewRunLengthWM()", err)
+	}
+	if rlwm.Size() != uint64(len(src)) {
+		t.Error("Expected", len(src), "Got", rlwm.Size())
+	}
+	if rlwm.NumOfRuns() != 200 {
+		t.Error("Expected", 200, "Got", rlwm.NumOfRuns())
+	}
+	for pos := uint64(0); pos <= uint64(len(src)); pos++ {
+		if pos < uint64(len(src)) {
+			if v, _ := rlwm.Lookup(pos); v != src[pos] {
+				t.Error("Expected", src[pos], "Got", v)
+			}
+		}
+		for _, c := range []uint64{0, 100, 400, 7} {
+			expected, _ := wm.Rank(c, pos)
+			if r, _ := rlwm.Rank(c, pos); r != expected {
+				t.Error("Expected", expected, "Got", r)
+			}
+		}
+	}
+	for _, c := range []uint64{0, 200, 300} {
+		for rank := uint64(1); rank <= wm.Freq(c)+1; rank += 5 {
+			expectedPos, expectedFound := wm.Select(c, rank)
+			if p, found := rlwm.Select(c, rank); p != expectedPos || found != expectedFound {
+				t.Error("Expected", expectedPos, expectedFound, "Got", p, found)
+			}
+		}
+		if f := rlwm.Freq(c); f != wm.Freq(c) {
+			t.Error("Expected", wm.Freq(c), "Got", f)
+		}
+	}
+	if _, found := rlwm.Select(7, 1); found {
+		t.Error("Expected", false, "Got", found)
+	}
+	for beg := uint64(0); beg < uint64(len(src)); beg += 13 {
+		for end := beg + 1; end <= uint64(len(src)); end += 17 {
+			expected := wm.FreqRange(100, 400, beg, end)
+			if f := rlwm.FreqRange(100, 400, beg, end); f != expected {
+				t.Error("Expected", expected, "Got", f)
+			}
+		}
+	}
+	if f := rlwm.FreqRange(400, 100, 0, 10); f != 0 {
+		t.Error("Expected", 0, "Got", f)
+	}
+	// The first positions of the runs take space proportional to the number of runs.
+	starts := rlwm.(*RunLengthWMData).starts
+	if starts.high.Size() > 3*rlwm.NumOfRuns()+1 {
+		t.Error("Expected at most", 3*rlwm.NumOfRuns()+1, "Got", starts.high.Size())
+	}
+
+	// Long runs have the lower bits of their first positions.
+	src = []uint64{}
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 1000+i*37; j++ {
+			src = append(src, uint64(i%3))
+		}
+	}
+	wm, _ = NewWM(src)
+	rlwm, _ = NewRunLengthWM(src)
+	for pos := uint64(0); pos < uint64(len(src)); pos += 7 {
+		if v, _ := rlwm.Lookup(pos); v != src[pos] {
+			t.Error("Expected", src[pos], "Got", v)
+		}
+		expected, _ := wm.Rank(1, pos)
+		if r, _ := rlwm.Rank(1, pos); r != expected {
+			t.Error("Expected", expected, "Got", r)
+		}
+	}
+	for rank := uint64(1); rank <= wm.Freq(2); rank += 11 {
+		expectedPos, _ := wm.Select(2, rank)
+		if p, _ := rlwm.Select(2, rank); p != expectedPos {
+			t.Error("Expected", expectedPos, "Got", p)
+		}
+	}
+	if w := rlwm.(*RunLengthWMData).starts.lowWidth; w == 0 {
+		t.Error("Expected lower bits Got", w)
+	}
+
+	if _, err := NewRunLengthWM([]uint64{}); err != ErrorEmpty {
+		t.Error("Expected", ErrorEmpty, "Got", err)
+	}
+}
+
+func TestMultiary(t *testing.T) {
+	src := make([]uint64, 2000)
+	for i := range src {
+		src[i] = uint64(i*i*31) % 1000
+	}
+	wm, _ := NewWM(src)
+	for _, symbolBitNum := range []uint64{2, 3} {
+		mwm, err := NewMultiaryWM(src, symbolBitNum)
+		if err != nil {
+			t.Error("Unexpected error in NewMultiaryWM()", err)
+		}
+		// 10 bits of alphabet are stored in 5 or 4 levels.
+		if levels := len(mwm.(*MultiaryWMData).levels); levels != int((10+symbolBitNum-1)/symbolBitNum) {
+			t.Error("Expected", (10+symbolBitNum-1)/symbolBitNum, "Got", levels)
+		}
+		for pos := uint64(0); pos < uint64(len(src)); pos += 7 {
+			c := src[pos]
+			if v, _ := mwm.Lookup(pos); v != c {
+				t.Error("Expected", c, "Got", v)
+			}
+			rank, lessThan, moreThan := wm.RankAll(c, pos/2, pos)
+			if r, l, m := mwm.RankAll(c, pos/2, pos); r != rank || l != lessThan || m != moreThan {
+				t.Error("Expected", rank, lessThan, moreThan, "Got", r, l, m)
+			}
+			expected, _ := wm.SelectFromPos(c, pos/2, 1)
+			if p, _ := mwm.SelectFromPos(c, pos/2, 1); p != expected {
+				t.Error("Expected", expected, "Got", p)
+			}
+			expectedPos, expectedVal := wm.QuantileRange(pos/2, pos+1, pos/4)
+			if p, v := mwm.QuantileRange(pos/2, pos+1, pos/4); p != expectedPos || v != expectedVal {
+				t.Error("Expected", expectedPos, expectedVal, "Got", p, v)
+			}
+			expectedPos, expectedVal = wm.NextValue(pos/2, pos+1, c+1)
+			if p, v := mwm.NextValue(pos/2, pos+1, c+1); p != expectedPos || v != expectedVal {
+				t.Error("Expected", expectedPos, expectedVal, "Got", p, v)
+			}
+			if f := mwm.FreqRange(100, c+1, 0, pos); f != wm.FreqRange(100, c+1, 0, pos) {
+				t.Error("Expected", wm.FreqRange(100, c+1, 0, pos), "Got", f)
+			}
+		}
+		// The symbols less than s are counted at once in each word.
+		seq := &mwm.(*MultiaryWMData).levels[1]
+		for s := uint64(0); s < uint64(1)<<symbolBitNum; s++ {
+			less := uint64(0)
+			for pos := uint64(0); pos <= seq.size; pos++ {
+				if pos%13 == 0 || pos == seq.size {
+					if r := seq.rankLess(s, pos); r != less {
+						t.Error("Expected", less, "Got", r)
+					}
+				}
+				if pos < seq.size && seq.get(pos) < s {
+					less++
+				}
+			}
+		}
+		expectedList := wm.ListMinRange(100, 900, 10, 1900, 5)
+		list := mwm.ListMinRange(100, 900, 10, 1900, 5)
+		if len(list) != len(expectedList) {
+			t.Error("Expected", expectedList, "Got", list)
+		}
+		for i := range list {
+			if list[i] != expectedList[i] {
+				t.Error("Expected", expectedList[i], "Got", list[i])
+			}
+		}
+		if _, err := mwm.Checked().Rank(1000, 0); err != ErrInvalidSymbol {
+			t.Error("Expected", ErrInvalidSymbol, "Got", err)
+		}
+		if err := mwm.Validate(WithLookupSamples(100)); err != nil {
+			t.Error("Unexpected error in Validate()", err)
+		}
+		// The symbol of the top level out of the alphabet is detected even if the counts agree with it.
+		// The top level of 3-bit symbols has the bits above the 10 bits of the alphabet.
+		if symbolBitNum == 3 {
+			corrupted, _ := NewMultiaryWM(src, symbolBitNum)
+			top := &corrupted.(*MultiaryWMData).levels[0]
+			top.words[0] |= uint64(1)<<symbolBitNum - 1
+			top.buildRanks()
+			corrupted.(*MultiaryWMData).counts[0] = top.counts()
+			var levelErr *LevelError
+			if err := corrupted.Validate(); !errors.As(err, &levelErr) || levelErr.Level != 0 {
+				t.Error("Expected", "LevelError of level 0", "Got", err)
+			}
+		}
+
+		buf, err := mwm.MarshalBinary()
+		if err != nil {
+			t.Error("Unexpected error in MarshalBinary()", err)
+		}
+		mwm2, err := NewMultiaryWMFromBinary(buf)
+		if err != nil {
+			t.Error("Unexpected error in UnmarshalBinary()", err)
+		}
+		if v, _ := mwm2.Lookup(1999); v != src[1999] {
+			t.Error("Expected", src[1999], "Got", v)
+		}
+		if _, err := NewWMFromBinary(buf); !errors.Is(err, ErrorInvalidFormat) {
+			t.Error("Expected", ErrorInvalidFormat, "Got", err)
+		}
+		buf[len(buf)-5] ^= 1
+		if _, err := NewMultiaryWMFromBinary(buf); err == nil {
+			t.Error("Expected error for corrupted binary")
+		}
+	}
+
+	if _, err := NewMultiaryWM(src, 4); err != ErrorSymbolBitNum {
+		t.Error("Expected", ErrorSymbolBitNum, "Got", err)
+	}
+	if _, err := NewMultiaryWM([]uint64{}, 2); err != ErrorEmpty {
+		t.Error("Expected", ErrorEmpty, "Got", err)
+	}
+	buf, _ := wm.MarshalBinary()
+	if _, err := NewMultiaryWMFromBinary(buf); !errors.Is(err, ErrorInvalidFormat) {
+		t.Error("Expected", ErrorInvalidFormat, "Got", err)
+	}
+}
+
+func TestDynamic(t *testing.T) {
+	src := []uint64{1, 3, 1, 4, 2, 1, 10}
+	dwm, err := NewDynamicWM(src)
+	if err != nil {
+		t.Error("Unexpected error in NewDynamicWM()", err)
+	}
+	expected := append([]uint64{}, src...)
+	check := func() {
+		if dwm.Size() != uint64(len(expected)) {
+			t.Error("Expected", len(expected), "Got", dwm.Size())
+		}
+		wm, _ := NewWMWithOptions(expected, WithAlphabetSize(dwm.(*DynamicWMData).alphabetNum))
+		for pos := uint64(0); pos < uint64(len(expected)); pos++ {
+			if v, _ := dwm.Lookup(pos); v != expected[pos] {
+				t.Error("Expected", expected[pos], "Got", v)
+			}
+			rank, _ := wm.Rank(expected[pos], pos)
+			if r, _ := dwm.Rank(expected[pos], pos); r != rank {
+				t.Error("Expected", rank, "Got", r)
+			}
+			p, _ := dwm.Select(expected[pos], rank+1)
+			if p != pos {
+				t.Error("Expected", pos, "Got", p)
+			}
+		}
+		expectedPos, expectedVal := wm.QuantileRange(0, uint64(len(expected)), 3)
+		if p, v := dwm.QuantileRange(0, uint64(len(expected)), 3); p != expectedPos || v != expectedVal {
+			t.Error("Expected", expectedPos, expectedVal, "Got", p, v)
+		}
+		if err := dwm.Validate(WithLookupSamples(10)); err != nil {
+			t.Error("Unexpected error in Validate()", err)
+		}
+	}
+
+	dwm.Insert(0, 7)
+	expected = append([]uint64{7}, expected...)
+	check()
+	dwm.Insert(uint64(len(expected)), 15)
+	expected = append(expected, 15)
+	check()
+	dwm.Delete(3)
+	expected = append(expected[:3], expected[4:]...)
+	check()
+	dwm.Set(2, 0)
+	expected[2] = 0
+	check()
+	// Enough insertions to split the leaves of the bit vectors.
+	for i := 0; i < 3000; i++ {
+		dwm.Insert(uint64(i%len(expected)), uint64(i%16))
+		expected = append(expected[:i%len(expected)], append([]uint64{uint64(i % 16)}, expected[i%len(expected):]...)...)
+	}
+	check()
+	for i := 0; i < 2900; i++ {
+		dwm.Delete(uint64(i % len(expected)))
+		expected = append(expected[:i%len(expected)], expected[i%len(expected)+1:]...)
+	}
+	check()
+	// The underfull leaves are merged, so that the number of leaves follows the number of bits.
+	for i := range dwm.(*DynamicWMData).bv {
+		bv := dwm.(*DynamicWMData).bv[i].(*dynamicBitVector)
+		if leaves := countDynamicLeaves(bv.root); leaves > 2*bv.root.size/dynamicLeafBits+1 {
+			t.Error("Expected at most", 2*bv.root.size/dynamicLeafBits+1, "leaves Got", leaves)
+		}
+	}
+
+	if err := dwm.Insert(0, 16); err != ErrorOutOfAlphabet {
+		t.Error("Expected", ErrorOutOfAlphabet, "Got", err)
+	}
+	if err := dwm.Insert(uint64(len(expected))+1, 1); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+	if err := dwm.Delete(uint64(len(expected))); err != ErrOutOfRange {
+		t.Error("Expected", ErrOutOfRange, "Got", err)
+	}
+	if err := dwm.Set(0, NotFound); err != ErrorReservedValue {
+		t.Error("Expected", ErrorReservedValue, "Got", err)
+	}
+
+	buf, _ := dwm.MarshalBinary()
+	dwm2, err := NewDynamicWMFromBinary(buf)
+	if err != nil {
+		t.Error("Unexpected error in UnmarshalBinary()", err)
+	}
+	dwm2.Insert(0, 5)
+	if v, _ := dwm2.Lookup(1); v != expected[0] {
+		t.Error("Expected", expected[0], "Got", v)
+	}
+	// The binary of the static matrix is converted.
+	wm, _ := NewWM(src)
+	buf, _ = wm.MarshalBinary()
+	dwm2, err = NewDynamicWMFromBinary(buf)
+	if err != nil {
+		t.Error("Unexpected error in UnmarshalBinary()", err)
+	}
+	dwm2.Delete(0)
+	if v, _ := dwm2.Lookup(0); v != src[1] {
+		t.Error("Expected", src[1], "Got", v)
+	}
+
+	dwm, err = NewDynamicWM(nil, WithAlphabetSize(4))
+	if err != nil {
+		t.Error("Unexpected error in NewDynamicWM()", err)
+	}
+	dwm.Insert(0, 3)
+	dwm.Insert(0, 2)
+	if r, _ := dwm.Rank(3, 2); r != 1 {
+		t.Error("Expected", 1, "Got", r)
+	}
+	if _, err := NewDynamicWM(nil); err != ErrorEmpty {
+		t.Error("Expected", ErrorEmpty, "Got", err)
+	}
+}
+
+func countDynamicLeaves(n *dynamicNode) uint64 {
+	if n.words != nil {
+		return 1
+	}
+	return countDynamicLeaves(n.left) + countDynamicLeaves(n.right)
+}
+
+func TestAppender(t *testing.T) {
+	if _, err := NewAppender(0); err != ErrorTailSize {
+		t.Error("Expected", ErrorTailSize, "Got", err)
+	}
+	a, err := NewAppender(100)
+	if err != nil {
+		t.Error("Unexpected error in NewAppender()", err)
+	}
+	var src []uint64
+	done := make(chan bool)
+	go func() {
+		// Queries run concurrently with the appends and the merges.
+		for i := 0; i < 100; i++ {
+			a.FreqRange(0, 10, 0, a.Size())
+		}
+		done <- true
+	}()
+	for i := 0; i < 50; i++ {
+		batch := make([]uint64, 37)
+		for j := range batch {
+			batch[j] = uint64((i*37+j)*(i*37+j)) % 50
+		}
+		if err := a.Append(batch...); err != nil {
+			t.Error("Unexpected error in Append()", err)
+		}
+		src = append(src, batch...)
+	}
+	<-done
+	if err := a.Wait(); err != nil {
+		t.Error("Unexpected error in Wait()", err)
+	}
+	if a.Size() != uint64(len(src)) {
+		t.Error("Expected", len(src), "Got", a.Size())
+	}
+	// 18 sealed tails are merged so that each segment is at least twice as large as the next one.
+	if n := a.NumOfSegments(); n > 4 {
+		t.Error("Expected", "at most 4 segments", "Got", n)
+	}
+
+	wm, _ := NewWM(src)
+	size := uint64(len(src))
+	for pos := uint64(0); pos < size; pos += 17 {
+		c := src[pos]
+		if v, _ := a.Lookup(pos); v != c {
+			t.Error("Expected", c, "Got", v)
+		}
+		expected, _ := wm.Rank(c, pos)
+		if r, _ := a.Rank(c, pos); r != expected {
+			t.Error("Expected", expected, "Got", r)
+		}
+		if p, _ := a.Select(c, expected+1); p != pos {
+			t.Error("Expected", pos, "Got", p)
+		}
+		if f := a.FreqRange(10, c+1, pos/2, pos); f != wm.FreqRange(10, c+1, pos/2, pos) {
+			t.Error("Expected", wm.FreqRange(10, c+1, pos/2, pos), "Got", f)
+		}
+		expectedPos, expectedVal := wm.QuantileRange(pos/2, size, pos/3)
+		if p, v := a.QuantileRange(pos/2, size, pos/3); p != expectedPos || v != expectedVal {
+			t.Error("Expected", expectedPos, expectedVal, "Got", p, v)
+		}
+	}
+	for _, list := range [][2][]ListResult{
+		{wm.ListMinRange(5, 40, 100, 1500, 10), a.ListMinRange(5, 40, 100, 1500, 10)},
+		{wm.ListMaxRange(5, 40, 100, 1500, 10), a.ListMaxRange(5, 40, 100, 1500, 10)},
+	} {
+		if len(list[0]) != len(list[1]) {
+			t.Error("Expected", list[0], "Got", list[1])
+			continue
+		}
+		for i := range list[0] {
+			if list[0][i] != list[1][i] {
+				t.Error("Expected", list[0][i], "Got", list[1][i])
+			}
+		}
+	}
+	mode := a.ListModeRange(0, 50, 0, size, 3)
+	expectedMode := wm.ListModeRange(0, 50, 0, size, 3)
+	if len(mode) != 3 {
+		t.Error("Expected", 3, "Got", len(mode))
+	}
+	for i := range mode {
+		if mode[i].Freq != expectedMode[i].Freq {
+			t.Error("Expected", expectedMode[i], "Got", mode[i])
+		}
+		if i > 0 && mode[i-1].Freq == mode[i].Freq && mode[i-1].C > mode[i].C {
+			t.Error("Expected", "ascending characters of the same frequency", "Got", mode)
+		}
+	}
+
+	// The values only in the tail are out of the range of the characters.
+	zeros, _ := NewAppender(8)
+	zeros.Append(0, 0, 0)
+	if list := zeros.ListMinRange(1, 5, 0, 3, 10); len(list) != 0 {
+		t.Error("Expected", 0, "Got", len(list))
+	}
+	if list := zeros.ListModeRange(0, 5, 0, 3, 10); len(list) != 1 || list[0] != (ListResult{0, 3}) {
+		t.Error("Expected", ListResult{0, 3}, "Got", list)
+	}
+
+	if err := a.Append(1, NotFound); err != ErrorReservedValue {
+		t.Error("Expected", ErrorReservedValue, "Got", err)
+	}
+	if a.Size() != size {
+		t.Error("Expected", size, "Got", a.Size())
+	}
+}
+
+func TestConcat(t *testing.T) {
+	srcA := []uint64{3, 1, 0, 2, 3, 1, 1}
+	srcB := []uint64{700, 5, 3, 1000, 0, 5}
+	all := append(append([]uint64{}, srcA...), srcB...)
+	a, _ := NewWM(srcA)
+	b, _ := NewWM(srcB)
+	wm, err := Concat(a, b)
+	if err != nil {
+		t.Error("Expected", nil, "Got", err)
+		return
+	}
+	expected, _ := NewWM(all)
+	if wm.Size() != expected.Size() {
+		t.Error("Expected", expected.Size(), "Got", wm.Size())
+	}
+	for pos, c := range all {
+		if v, _ := wm.Lookup(uint64(pos)); v != c {
+			t.Error("Expected", c, "Got", v)
+		}
+		expectedRank, _ := expected.Rank(c, uint64(pos))
+		if r, _ := wm.Rank(c, uint64(pos)); r != expectedRank {
+			t.Error("Expected", expectedRank, "Got", r)
+		}
+	}
+	// The matrix of fewer levels may be the second one.
+	wm, err = Concat(b, a)
+	if err != nil {
+		t.Error("Expected", nil, "Got", err)
+		return
+	}
+	for pos, c := range append(append([]uint64{}, srcB...), srcA...) {
+		if v, _ := wm.Lookup(uint64(pos)); v != c {
+			t.Error("Expected", c, "Got", v)
+		}
+	}
+	if err := wm.(*WMData).Validate(); err != nil {
+		t.Error("Expected", nil, "Got", err)
+	}
+
+	weighted, _ := NewWeightedWM(srcA, []uint64{1, 2, 3, 4, 5, 6, 7})
+	if _, err := Concat(weighted.(WaveletMatrix), b); err != ErrorConcatWeights {
+		t.Error("Expected", ErrorConcatWeights, "Got", err)
+	}
+	// The other matrices are decoded and rebuilt.
+	compact, _ := NewCompactWM(srcA)
+	multiary, _ := NewMultiaryWM(srcB, 2)
+	wm, err = Concat(compact, multiary)
+	if err != nil {
+		t.Error("Expected", nil, "Got", err)
+		return
+	}
+	for pos, c := range all {
+		if v, _ := wm.Lookup(uint64(pos)); v != c {
+			t.Error("Expected", c, "Got", v)
+		}
+	}
+}
+
+func TestExtractSlice(t *testing.T) {
+	src := []uint64{1, 300, 2, 7, 2, 1, 4, 0, 7, 3}
+	wm, _ := NewWM(src)
+	cwm, _ := NewCompactWM(src)
+	mwm, _ := NewMultiaryWM(src, 3)
+	for _, wm := range []WaveletMatrix{wm, cwm, mwm} {
+		values := wm.Extract(2, 9)
+		if len(values) != 7 {
+			t.Error("Expected", 7, "Got", len(values))
+		}
+		for i := range values {
+			if values[i] != src[2+i] {
+				t.Error("Expected", src[2+i], "Got", values[i])
+			}
+		}
+		if values := wm.Extract(4, 4); len(values) != 0 {
+			t.Error("Expected", 0, "Got", len(values))
+		}
+		if values := wm.Extract(5, 11); values != nil {
+			t.Error("Expected", nil, "Got", values)
+		}
+
+		slice, err := wm.Slice(2, 9)
+		if err != nil {
+			t.Fatal("Unexpected error in Slice()", err)
+		}
+		if slice.Size() != 7 {
+			t.Error("Expected", 7, "Got", slice.Size())
+		}
+		expected, _ := NewWM(src[2:9])
+		for pos, c := range src[2:9] {
+			if v, _ := slice.Lookup(uint64(pos)); v != c {
+				t.Error("Expected", c, "Got", v)
+			}
+			expectedRank, _ := expected.Rank(c, uint64(pos))
+			if r, _ := slice.Rank(c, uint64(pos)); r != expectedRank {
+				t.Error("Expected", expectedRank, "Got", r)
+			}
+		}
+		if err := slice.Validate(); err != nil {
+			t.Error("Expected", nil, "Got", err)
+		}
+		if _, err := wm.Slice(3, 3); err != ErrorEmpty {
+			t.Error("Expected", ErrorEmpty, "Got", err)
+		}
+		if _, err := wm.Slice(5, 11); err != ErrOutOfRange {
+			t.Error("Expected", ErrOutOfRange, "Got", err)
+		}
+	}
+
+	// The alphabet is shrunk to the largest value of the subarray.
+	slice, _ := wm.Slice(2, 9)
+	expected, _ := NewWM(src[2:9])
+	if a, b := slice.(*WMData).alphabetBitNum, expected.(*WMData).alphabetBitNum; a != b {
+		t.Error("Expected", b, "Got", a)
+	}
+}
+
+func TestDistinctRange(t *testing.T) {
+	src := []uint64{5, 1, 5, 300, 2, 1, 7, 5, 2}
+	wm, _ := NewWM(src)
+	mwm, _ := NewMultiaryWM(src, 2)
+	for _, wm := range []WaveletMatrix{wm, mwm} {
+		if d := wm.DistinctRange(0, uint64(len(src))); d != 5 {
+			t.Error("Expected", 5, "Got", d)
+		}
+		if d := wm.DistinctRange(1, 6); d != 4 {
+			t.Error("Expected", 4, "Got", d)
+		}
+		if d := wm.DistinctRange(3, 3); d != 0 {
+			t.Error("Expected", 0, "Got", d)
+		}
+		if d := wm.DistinctValueRange(2, 7, 0, uint64(len(src))); d != 2 {
+			t.Error("Expected", 2, "Got", d)
+		}
+		if d := wm.DistinctValueRange(7, 2, 0, uint64(len(src))); d != 0 {
+			t.Error("Expected", 0, "Got", d)
+		}
+	}
+
+	compact, _ := NewCompactWM(src)
+	if d := compact.DistinctRange(1, 6); d != 4 {
+		t.Error("Expected", 4, "Got", d)
+	}
+	if d := compact.DistinctValueRange(3, 1000, 0, uint64(len(src))); d != 3 {
+		t.Error("Expected", 3, "Got", d)
+	}
+}
@@ -0,0 +1,278 @@
+package waveletmatrix
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// ValidateOption configures the checks of Validate.
+type ValidateOption func(config *validateConfig)
+
+type validateConfig struct {
+	samples uint64
+}
+
+// LevelError reports the level of Wavelet-Matrix which is broken.
+type LevelError struct {
+	Level uint64
+	Err   error
+}
+
+var (
+	// ErrorInconsistent indicates that Wavelet-Matrix is not internally consistent.
+	ErrorInconsistent = errors.New("Validate: inconsistent Wavelet-Matrix")
+)
+
+// WithLookupSamples makes Validate check that Select finds the position of the value returned by Lookup,
+// at samples positions spread evenly over the array.
+func WithLookupSamples(samples uint64) ValidateOption {
+	return func(config *validateConfig) {
+		config.samples = samples
+	}
+}
+
+func (e *LevelError) Error() string {
+	return fmt.Sprintf("level %d: %v", e.Level, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *LevelError) Unwrap() error {
+	return e.Err
+}
+
+func inconsistent(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrorInconsistent}, args...)...)
+}
+
+func levelInconsistent(level uint64, format string, args ...interface{}) error {
+	return &LevelError{level, inconsistent(format, args...)}
+}
+
+/*
+Validate checks that Wavelet-Matrix is internally consistent without rebuilding it.
+The bit vector of every level must have `size` bits, and its number of 0 bits must match the zero count of the level,
+which is derived from the node positions for the legacy format. The cumulative weights are checked, if any.
+
+The error wraps ErrorInconsistent. If the broken part is a level, the error is *LevelError.
+*/
+func (wm *WMData) Validate(opts ...ValidateOption) error {
+	config := &validateConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if wm.alphabetBitNum > 64 || (wm.alphabetBitNum < 64 && wm.alphabetNum > uint64(1)<<wm.alphabetBitNum) {
+		return inconsistent("alphabet size %d does not fit in %d levels", wm.alphabetNum, wm.alphabetBitNum)
+	}
+	if uint64(len(wm.bv)) != wm.alphabetBitNum || uint64(len(wm.zeros)) != wm.alphabetBitNum {
+		return inconsistent("%d bit vectors and %d zero counts for %d levels", len(wm.bv), len(wm.zeros), wm.alphabetBitNum)
+	}
+	for i := uint64(0); i < wm.alphabetBitNum; i++ {
+		if err := wm.validateLevel(i); err != nil {
+			return err
+		}
+	}
+	if err := wm.validateWeightSums(); err != nil {
+		return err
+	}
+	if config.samples == 0 || wm.size == 0 {
+		return nil
+	}
+	step := wm.size / config.samples
+	if step == 0 {
+		step = 1
+	}
+	for pos := uint64(0); pos < wm.size; pos += step {
+		if err := wm.validatePath(pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (wm *WMData) validateLevel(i uint64) error {
+	bv := wm.bv[i]
+	if bv.Size() != wm.size {
+		return levelInconsistent(i, "bit vector has %d bits, expected %d", bv.Size(), wm.size)
+	}
+	zeros, ones := bv.NumOfBits(false), bv.NumOfBits(true)
+	if zeros+ones != wm.size {
+		return levelInconsistent(i, "%d 0 bits and %d 1 bits do not sum to %d", zeros, ones, wm.size)
+	}
+	if zeros != wm.zeros[i] {
+		return levelInconsistent(i, "number of 0 bits is %d, bit vector has %d", wm.zeros[i], zeros)
+	}
+	rank0, err0 := bv.Rank0(wm.size)
+	rank1, err1 := bv.Rank1(wm.size)
+	if err0 != nil || err1 != nil || rank0 != zeros || rank1 != ones {
+		return levelInconsistent(i, "rank of the whole bit vector does not match the number of bits")
+	}
+	return nil
+}
+
+func (wm *WMData) validateWeightSums() error {
+	if len(wm.weightSums) == 0 {
+		return nil
+	}
+	if uint64(len(wm.weightSums)) != wm.alphabetBitNum+1 {
+		return inconsistent("%d weight tables for %d levels", len(wm.weightSums), wm.alphabetBitNum)
+	}
+	total := wm.weightSums[0][len(wm.weightSums[0])-1]
+	for i, sums := range wm.weightSums {
+		if uint64(len(sums)) != wm.size+1 || sums[0] != 0 {
+			return inconsistent("weight table %d has %d entries, expected %d", i, len(sums), wm.size+1)
+		}
+		if sums[len(sums)-1] != total {
+			return inconsistent("weight table %d sums to %d, expected %d", i, sums[len(sums)-1], total)
+		}
+	}
+	return nil
+}
+
+// validatePath follows the element at pos down to the bottom level, and checks that Select goes back up
+// through the same positions.
+func (wm *WMData) validatePath(pos uint64) error {
+	path := make([]uint64, wm.alphabetBitNum+1)
+	path[0] = pos
+	c := uint64(0)
+	for i := uint64(0); i < wm.alphabetBitNum; i++ {
+		b, err := wm.bv[i].Get(path[i])
+		if err != nil {
+			return levelInconsistent(i, "position %d is not accessible", path[i])
+		}
+		c <<= 1
+		if b {
+			c |= 1
+		}
+		path[i+1] = wm.nextPos(i, path[i], b)
+	}
+	if c >= wm.alphabetNum {
+		return inconsistent("value %d at %d is out of the alphabet", c, pos)
+	}
+	index := path[wm.alphabetBitNum]
+	for i := int(wm.alphabetBitNum) - 1; i >= 0; i-- {
+		b := wm.bitOf(c, uint64(i))
+		if b {
+			index -= wm.zeros[i]
+		}
+		var err error
+		if index, err = wm.bv[i].Select(index, b); err != nil || index != path[i] {
+			return levelInconsistent(uint64(i), "Select does not return to position %d of Lookup(%d)", path[i], pos)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the values are strictly ascending, and validates the inner Wavelet-Matrix.
+func (cwm *CompactWMData) Validate(opts ...ValidateOption) error {
+	for i := 1; i < len(cwm.values); i++ {
+		if cwm.values[i-1] >= cwm.values[i] {
+			return inconsistent("values are not strictly ascending at %d", i)
+		}
+	}
+	if cwm.wm.alphabetNum > uint64(len(cwm.values)) {
+		return inconsistent("alphabet size %d exceeds %d values", cwm.wm.alphabetNum, len(cwm.values))
+	}
+	return cwm.wm.Validate(opts...)
+}
+
+// Validate checks the words of every level against the rank samples, which are only bounded on loading,
+// and the checksum of the mapping. The whole mapping is read.
+func (mwm *MappedWMData) Validate(opts ...ValidateOption) error {
+	config := &validateConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if mwm.data == nil {
+		return ErrorMappedClosed
+	}
+	for i := uint64(0); i < mwm.alphabetBitNum; i++ {
+		bv := &mwm.bv[i]
+		ones := uint64(0)
+		for j := uint64(0); j < uint64(len(bv.words)); j++ {
+			if j%mappedBlockWords == 0 && bv.ranks[j/mappedBlockWords] != ones {
+				return levelInconsistent(i, "rank sample %d is %d, expected %d", j/mappedBlockWords, bv.ranks[j/mappedBlockWords], ones)
+			}
+			ones += uint64(bits.OnesCount64(bv.words[j]))
+		}
+		if r := mwm.size % 64; r != 0 && bv.words[len(bv.words)-1]>>r != 0 {
+			return levelInconsistent(i, "bits beyond the size are set")
+		}
+		if bv.ranks[len(bv.ranks)-1] != ones {
+			return levelInconsistent(i, "number of 1 bits is %d, expected %d", bv.ranks[len(bv.ranks)-1], ones)
+		}
+	}
+	body := uint64(len(mwm.data)) - sizeOfInt32
+	if crc32.Checksum(mwm.data[:body], crc32cTable) != binary.LittleEndian.Uint32(mwm.data[body:]) {
+		return ErrorChecksumMismatch
+	}
+	if config.samples == 0 || mwm.size == 0 {
+		return nil
+	}
+	step := mwm.size / config.samples
+	if step == 0 {
+		step = 1
+	}
+	for pos := uint64(0); pos < mwm.size; pos += step {
+		c, _ := mwm.Lookup(pos)
+		if c >= mwm.alphabetNum {
+			return inconsistent("value %d at %d is out of the alphabet", c, pos)
+		}
+		rank, _ := mwm.Rank(c, pos+1)
+		if p, found := mwm.Select(c, rank); !found || p != pos {
+			return inconsistent("Select does not return to position %d of Lookup", pos)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the symbols of every level fit in the alphabet and match the symbol counts.
+// The rank samples and the symbol counts are rebuilt on loading, so that the checks are on the symbols themselves.
+func (mwm *MultiaryWMData) Validate(opts ...ValidateOption) error {
+	config := &validateConfig{}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if mwm.alphabetBitNum > 64 || (mwm.alphabetBitNum < 64 && mwm.alphabetNum > uint64(1)<<mwm.alphabetBitNum) {
+		return inconsistent("alphabet size %d does not fit in %d bits", mwm.alphabetNum, mwm.alphabetBitNum)
+	}
+	if uint64(len(mwm.levels)) != mwm.levelNum() || len(mwm.counts) != len(mwm.levels) {
+		return inconsistent("%d levels and %d symbol counts for %d bits of alphabet", len(mwm.levels), len(mwm.counts), mwm.alphabetBitNum)
+	}
+	for i := range mwm.levels {
+		seq := &mwm.levels[i]
+		if seq.size != mwm.size {
+			return levelInconsistent(uint64(i), "sequence has %d symbols, expected %d", seq.size, mwm.size)
+		}
+		counts := seq.counts()
+		for s := range counts {
+			if counts[s] != mwm.counts[i][s] {
+				return levelInconsistent(uint64(i), "number of symbols less than %d is %d, sequence has %d", s, mwm.counts[i][s], counts[s])
+			}
+		}
+		// The symbols of the level must not exceed the bits of the largest character at the level.
+		if maxSymbol := (mwm.alphabetNum - 1) >> mwm.shiftOf(uint64(i)); maxSymbol+1 < uint64(len(counts)) && counts[maxSymbol+1] != seq.size {
+			return levelInconsistent(uint64(i), "%d symbols exceed %d", seq.size-counts[maxSymbol+1], maxSymbol)
+		}
+	}
+	if config.samples == 0 || mwm.size == 0 {
+		return nil
+	}
+	step := mwm.size / config.samples
+	if step == 0 {
+		step = 1
+	}
+	for pos := uint64(0); pos < mwm.size; pos += step {
+		c := mwm.lookup(pos)
+		if c >= mwm.alphabetNum {
+			return inconsistent("value %d at %d is out of the alphabet", c, pos)
+		}
+		rank := mwm.rank(c, 0, pos+1)
+		if p, found := mwm.selectFromPos(c, 0, rank); !found || p != pos {
+			return inconsistent("Select does not return to position %d of Lookup", pos)
+		}
+	}
+	return nil
+}
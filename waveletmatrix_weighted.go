@@ -0,0 +1,120 @@
+package waveletmatrix
+
+import (
+	"errors"
+)
+
+// WeightedWaveletMatrix is interface of Wavelet-Matrix whose elements have weights.
+type WeightedWaveletMatrix interface {
+	WaveletMatrix
+	SumRange(minC, maxC, begPos, endPos uint64) uint64
+}
+
+var (
+	// ErrorWeightsLength indicates that the length of weights differs from the length of the array.
+	ErrorWeightsLength = errors.New("Length of weights differs from length of array.")
+)
+
+// NewWeightedWM builds Wavelet-Matrix whose i-th element has the weight weights[i].
+// WithWorkers is ignored, since the weighted levels are built sequentially.
+func NewWeightedWM(src, weights []uint64, opts ...Option) (WeightedWaveletMatrix, error) {
+	if len(weights) != len(src) {
+		return nil, ErrorWeightsLength
+	}
+	builder := newWMBuilder(opts)
+	builder.weights = weights
+	wm, err := builder.Build(src)
+	if err != nil {
+		return nil, err
+	}
+	return wm.(*WMData), nil
+}
+
+// buildWeightedLevels builds the levels of builder.wm from cur, and the cumulative weights of each level.
+// The content of cur is destroyed.
+func (builder *wmBuilderData) buildWeightedLevels(cur []uint64) error {
+	wm := builder.wm
+	weights := make([]uint64, wm.size)
+	copy(weights, builder.weights)
+	wm.weightSums = make([][]uint64, wm.alphabetBitNum+1)
+	wm.weightSums[0] = cumulativeSums(weights)
+
+	next := make([]uint64, wm.size)
+	nextWeights := make([]uint64, wm.size)
+	for i := uint64(0); i < wm.alphabetBitNum; i++ {
+		shift := wm.alphabetBitNum - i - 1
+		zeros := uint64(0)
+		for j := uint64(0); j < wm.size; j++ {
+			if (cur[j]>>shift)&1 == 0 {
+				zeros++
+			}
+		}
+		wm.zeros[i] = zeros
+
+		bvBuilder := builder.backend.NewBuilder(builder.selectIndex)
+		zeroPos := uint64(0)
+		onePos := zeros
+		for j := uint64(0); j < wm.size; j++ {
+			bit := (cur[j] >> shift) & 1
+			bvBuilder.Set(j, toBool(bit))
+			if bit == 0 {
+				next[zeroPos] = cur[j]
+				nextWeights[zeroPos] = weights[j]
+				zeroPos++
+			} else {
+				next[onePos] = cur[j]
+				nextWeights[onePos] = weights[j]
+				onePos++
+			}
+		}
+		bv, err := bvBuilder.Build()
+		if err != nil {
+			return err
+		}
+		wm.bv[i] = bv
+		wm.weightSums[i+1] = cumulativeSums(nextWeights)
+		cur, next = next, cur
+		weights, nextWeights = nextWeights, weights
+	}
+	return nil
+}
+
+func cumulativeSums(weights []uint64) []uint64 {
+	sums := make([]uint64, len(weights)+1)
+	for i := 0; i < len(weights); i++ {
+		sums[i+1] = sums[i] + weights[i]
+	}
+	return sums
+}
+
+// SumRange returns the sum of weights of the elements minC <= c' < maxC in the subarray A[begPos ... endPos)
+// If Wavelet-Matrix is not weighted or the arguments are invalid, 0 is returned.
+func (wm *WMData) SumRange(minC, maxC, begPos, endPos uint64) uint64 {
+	if wm.weightSums == nil || checkListRange(minC, maxC, begPos, endPos, wm.size) != nil {
+		return 0
+	}
+	return wm.sumLessThan(maxC, begPos, endPos) - wm.sumLessThan(minC, begPos, endPos)
+}
+
+// sumLessThan returns the sum of weights of characters c' < c in the subarray A[begPos...endPos). c may be any value.
+func (wm *WMData) sumLessThan(c, begPos, endPos uint64) uint64 {
+	if c >= wm.alphabetNum {
+		return wm.weightSums[0][endPos] - wm.weightSums[0][begPos]
+	}
+	sum := uint64(0)
+	for i := uint64(0); i < wm.alphabetBitNum && begPos < endPos; i++ {
+		bv := wm.bv[i]
+		begZero, _ := bv.Rank0(begPos)
+		endZero, _ := bv.Rank0(endPos)
+
+		if wm.bitOf(c, i) {
+			sum += wm.weightSums[i+1][endZero] - wm.weightSums[i+1][begZero]
+			begPos = wm.zeros[i] + begPos - begZero
+			endPos = wm.zeros[i] + endPos - endZero
+		} else {
+			begPos = begZero
+			endPos = endZero
+		}
+	}
+	return sum
+}